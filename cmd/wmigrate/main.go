@@ -0,0 +1,110 @@
+// Command wmigrate applies the migrations of a directory to a connection of the [db] section of the config
+//
+//	wmigrate -config config/ -env prod -db main -dir migrations up
+//	wmigrate -config config/ -env prod -db main -dir migrations -target 12 migrate
+//	wmigrate -config config/ -env prod -db main -dir migrations -dry-run down
+//	wmigrate -config config/ -env prod -db main -dir migrations status
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/webediads/adsgolib/wconfig"
+	"github.com/webediads/adsgolib/wconnectors"
+	"github.com/webediads/adsgolib/wmigrate"
+)
+
+func main() {
+	configFolder := flag.String("config", "config/", "folder of the config.common.ini and config.<env>.ini files")
+	env := flag.String("env", "dev", "environment")
+	dbName := flag.String("db", "main", "name of the connection in the [db] section")
+	dir := flag.String("dir", "migrations", "folder of the <version>_<name>.up.sql and <version>_<name>.down.sql files")
+	target := flag.Int64("target", wmigrate.Latest, "version to migrate to, for the migrate command")
+	table := flag.String("table", "", "table of the applied versions, default schema_migrations")
+	dryRun := flag.Bool("dry-run", false, "print the statements instead of running them")
+	timeout := flag.Duration("timeout", 10*time.Minute, "maximum duration of the migrations")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: wmigrate [flags] up|down|migrate|status")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if !strings.HasSuffix(*configFolder, "/") {
+		*configFolder += "/"
+	}
+	wconfig.Config.ReadConfigFile(*configFolder, *env)
+	wconfig.Config.SetEnvironment(*env)
+	if err := wconnectors.RegisterDb(*dbName); err != nil {
+		exit(err)
+	}
+
+	migrations, err := wmigrate.LoadDir(*dir)
+	if err != nil {
+		exit(err)
+	}
+	migrator, err := wmigrate.New(*dbName, migrations, wmigrate.Settings{Table: *table, DryRun: *dryRun})
+	if err != nil {
+		exit(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var steps []wmigrate.Step
+	switch flag.Arg(0) {
+	case "up":
+		steps, err = migrator.Up(ctx)
+	case "down":
+		steps, err = migrator.Down(ctx)
+	case "migrate":
+		steps, err = migrator.Migrate(ctx, *target)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			exit(err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Missing {
+				state += " (missing)"
+			}
+			fmt.Printf("%04d_%s: %s\n", status.Version, status.Name, state)
+		}
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, step := range steps {
+		if *dryRun {
+			fmt.Printf("-- %s\n%s;\n\n", step, strings.Join(step.Statements, ";\n"))
+		} else {
+			fmt.Println(step)
+		}
+	}
+	if len(steps) == 0 && err == nil {
+		fmt.Println("nothing to migrate")
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+// exit prints the error and exits
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,273 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-sql-driver/mysql"
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+// the states of a circuit breaker
+const (
+	BreakerClosed   BreakerState = iota // the calls go through
+	BreakerOpen                         // the calls fail right away until the cooldown is over
+	BreakerHalfOpen                     // a few calls go through to check whether the server is back
+)
+
+// String returns the name of the state
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// ErrBreakerOpen is returned by the calls made while the breaker of the connection is open
+var ErrBreakerOpen = errors.New("wconnectors: circuit breaker is open")
+
+// default breaker settings
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+// BreakerSettings is the struct that is used for configuring a circuit breaker
+type BreakerSettings struct {
+	FailureThreshold int           // consecutive failures opening the breaker, default 5
+	Cooldown         time.Duration // how long the breaker stays open before letting a call through, default 10s
+	HalfOpenCalls    int           // calls let through at once when the cooldown is over, default 1
+}
+
+// Breaker stops calling a server that keeps failing, so that the callers do not all wait for its timeouts.
+// Only the failures of the server count, not the errors it answers such as a duplicate key or a cache miss
+type Breaker struct {
+	name          string
+	settings      BreakerSettings
+	mutex         sync.Mutex
+	state         BreakerState
+	failures      int
+	lastErr       error
+	openedAt      time.Time
+	halfOpenCalls int
+}
+
+// BreakerStatus is the state of a breaker as reported by Breakers
+type BreakerStatus struct {
+	Name     string
+	State    BreakerState
+	Failures int       // consecutive failures
+	OpenedAt time.Time // zero unless the breaker is open or half-open
+	LastErr  error
+}
+
+var breakers = make(map[string]*Breaker)
+var breakersMutex sync.Mutex
+
+// NewBreaker will instantiate a breaker, it is listed by Breakers under its name
+func NewBreaker(name string, settingsOpt ...BreakerSettings) *Breaker {
+	var settings BreakerSettings
+	if len(settingsOpt) > 0 {
+		settings = settingsOpt[0]
+	}
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = defaultBreakerThreshold
+	}
+	if settings.Cooldown <= 0 {
+		settings.Cooldown = defaultBreakerCooldown
+	}
+	if settings.HalfOpenCalls <= 0 {
+		settings.HalfOpenCalls = 1
+	}
+
+	breaker := &Breaker{name: name, settings: settings}
+	breakersMutex.Lock()
+	breakers[name] = breaker
+	breakersMutex.Unlock()
+	return breaker
+}
+
+// Breakers returns the state of the breakers sorted by name, ex: for a health check
+func Breakers() []BreakerStatus {
+	breakersMutex.Lock()
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, breaker := range breakers {
+		statuses = append(statuses, breaker.Status())
+	}
+	breakersMutex.Unlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Status returns the state of the breaker
+func (breaker *Breaker) Status() BreakerStatus {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return BreakerStatus{Name: breaker.name, State: breaker.state, Failures: breaker.failures, OpenedAt: breaker.openedAt, LastErr: breaker.lastErr}
+}
+
+// Allow tells whether a call can be made, it returns ErrBreakerOpen otherwise. Every allowed call must be followed by Done
+func (breaker *Breaker) Allow() error {
+	if breaker == nil {
+		return nil
+	}
+	breaker.mutex.Lock()
+	var transition breakerTransition
+	switch breaker.state {
+	case BreakerOpen:
+		if time.Since(breaker.openedAt) < breaker.settings.Cooldown {
+			breaker.mutex.Unlock()
+			return fmt.Errorf("%w: %s", ErrBreakerOpen, breaker.name)
+		}
+		transition = breaker.setState(BreakerHalfOpen, nil)
+		breaker.halfOpenCalls = 1
+	case BreakerHalfOpen:
+		if breaker.halfOpenCalls >= breaker.settings.HalfOpenCalls {
+			breaker.mutex.Unlock()
+			return fmt.Errorf("%w: %s", ErrBreakerOpen, breaker.name)
+		}
+		breaker.halfOpenCalls++
+	}
+	breaker.mutex.Unlock()
+	transition.log()
+	return nil
+}
+
+// ready tells whether Allow would let a call through, without counting it
+func (breaker *Breaker) ready() bool {
+	if breaker == nil {
+		return true
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	switch breaker.state {
+	case BreakerOpen:
+		return time.Since(breaker.openedAt) >= breaker.settings.Cooldown
+	case BreakerHalfOpen:
+		return breaker.halfOpenCalls < breaker.settings.HalfOpenCalls
+	}
+	return true
+}
+
+// Done records the outcome of an allowed call
+func (breaker *Breaker) Done(err error) {
+	if breaker == nil {
+		return
+	}
+	breaker.mutex.Lock()
+	transition := breaker.record(err)
+	breaker.mutex.Unlock()
+	transition.log()
+}
+
+// record counts the outcome of a call, the mutex must be held
+func (breaker *Breaker) record(err error) breakerTransition {
+	if isBreakerNeutral(err) {
+		// the call tells nothing about the server
+		if breaker.state == BreakerHalfOpen {
+			breaker.halfOpenCalls--
+		}
+		return breakerTransition{}
+	}
+	failed := isBreakerFailure(err)
+	switch breaker.state {
+	case BreakerClosed:
+		if !failed {
+			breaker.failures = 0
+			return breakerTransition{}
+		}
+		breaker.failures++
+		breaker.lastErr = err
+		if breaker.failures >= breaker.settings.FailureThreshold {
+			return breaker.setState(BreakerOpen, err)
+		}
+	case BreakerHalfOpen:
+		breaker.halfOpenCalls--
+		if failed {
+			breaker.lastErr = err
+			return breaker.setState(BreakerOpen, err)
+		}
+		breaker.failures = 0
+		return breaker.setState(BreakerClosed, nil)
+	}
+	// the calls allowed before the breaker opened do not count
+	return breakerTransition{}
+}
+
+// breakerTransition is the log of a change of state, it is written once the mutex is released
+// since the destinations of wlog may call connections that have a breaker
+type breakerTransition struct {
+	level   int
+	message string
+	fields  wlog.Option
+}
+
+// log writes the change of state, if any
+func (transition breakerTransition) log() {
+	if transition.message == "" {
+		return
+	}
+	wlog.Log(transition.level, transition.message, transition.fields)
+}
+
+// setState changes the state and returns its log, the mutex must be held
+func (breaker *Breaker) setState(state BreakerState, err error) breakerTransition {
+	breaker.state = state
+	transition := breakerTransition{
+		level:  wlog.LevelNotice,
+		fields: wlog.Fields(map[string]interface{}{"breaker": breaker.name, "state": state.String()}),
+	}
+	switch state {
+	case BreakerOpen:
+		breaker.openedAt = time.Now()
+		transition.level = wlog.LevelError
+		transition.message = fmt.Sprintf("circuit breaker %s open for %s after %d failures: %s", breaker.name, breaker.settings.Cooldown, breaker.failures, err)
+	case BreakerHalfOpen:
+		transition.message = "circuit breaker " + breaker.name + " half-open"
+	case BreakerClosed:
+		breaker.openedAt = time.Time{}
+		breaker.lastErr = nil
+		transition.message = "circuit breaker " + breaker.name + " closed"
+	}
+	return transition
+}
+
+// isBreakerNeutral tells whether an error is neither a success nor a failure of the server, ex: the caller gave up
+func isBreakerNeutral(err error) bool {
+	return err == driver.ErrSkip || errors.Is(err, context.Canceled) || errors.Is(err, ErrBreakerOpen)
+}
+
+// isBreakerFailure tells whether an error is a failure of the server rather than an answer of the server
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, sql.ErrTxDone) {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	var stateErr sqlStateError
+	if errors.As(err, &mysqlErr) || errors.As(err, &stateErr) {
+		return false
+	}
+	switch err {
+	case memcache.ErrCacheMiss, memcache.ErrCASConflict, memcache.ErrNotStored, memcache.ErrMalformedKey:
+		return false
+	}
+	return true
+}
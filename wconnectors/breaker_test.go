@@ -0,0 +1,90 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	errServer := errors.New("connection refused")
+
+	tests := []struct {
+		name     string
+		settings BreakerSettings
+		calls    []error // the outcomes of the allowed calls, in order
+		wait     time.Duration
+		want     BreakerState
+		allowed  bool // whether the next call is allowed
+	}{
+		{"closed", BreakerSettings{FailureThreshold: 2}, nil, 0, BreakerClosed, true},
+		{"below threshold", BreakerSettings{FailureThreshold: 2}, []error{errServer}, 0, BreakerClosed, true},
+		{"success resets the failures", BreakerSettings{FailureThreshold: 2}, []error{errServer, nil, errServer}, 0, BreakerClosed, true},
+		{"opens at threshold", BreakerSettings{FailureThreshold: 2}, []error{errServer, errServer}, 0, BreakerOpen, false},
+		{"answers are not failures", BreakerSettings{FailureThreshold: 1}, []error{sql.ErrNoRows, memcache.ErrCacheMiss}, 0, BreakerClosed, true},
+		{"cancels are not failures", BreakerSettings{FailureThreshold: 1}, []error{context.Canceled}, 0, BreakerClosed, true},
+		{"half-open after cooldown", BreakerSettings{FailureThreshold: 1, Cooldown: time.Millisecond}, []error{errServer}, 5 * time.Millisecond, BreakerOpen, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := NewBreaker("test "+test.name, test.settings)
+			for _, err := range test.calls {
+				if allowErr := breaker.Allow(); allowErr != nil {
+					t.Fatalf("Allow() = %v, want nil", allowErr)
+				}
+				breaker.Done(err)
+			}
+			if state := breaker.Status().State; state != test.want {
+				t.Errorf("state = %s, want %s", state, test.want)
+			}
+			time.Sleep(test.wait)
+			err := breaker.Allow()
+			if allowed := err == nil; allowed != test.allowed {
+				t.Errorf("Allow() = %v, allowed %v", err, test.allowed)
+			}
+			if err != nil && !errors.Is(err, ErrBreakerOpen) {
+				t.Errorf("Allow() = %v, want ErrBreakerOpen", err)
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	errServer := errors.New("connection refused")
+
+	tests := []struct {
+		name    string
+		outcome error
+		want    BreakerState
+	}{
+		{"success closes", nil, BreakerClosed},
+		{"failure opens again", errServer, BreakerOpen},
+		{"cancel stays half-open", context.Canceled, BreakerHalfOpen},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := NewBreaker("test "+test.name, BreakerSettings{FailureThreshold: 1, Cooldown: time.Millisecond})
+			breaker.Allow()
+			breaker.Done(errServer)
+			time.Sleep(5 * time.Millisecond)
+
+			if err := breaker.Allow(); err != nil {
+				t.Fatalf("Allow() = %v, want nil", err)
+			}
+			if state := breaker.Status().State; state != BreakerHalfOpen {
+				t.Fatalf("state = %s, want %s", state, BreakerHalfOpen)
+			}
+			if err := breaker.Allow(); !errors.Is(err, ErrBreakerOpen) {
+				t.Errorf("second half-open Allow() = %v, want ErrBreakerOpen", err)
+			}
+			breaker.Done(test.outcome)
+			if state := breaker.Status().State; state != test.want {
+				t.Errorf("state = %s, want %s", state, test.want)
+			}
+		})
+	}
+}
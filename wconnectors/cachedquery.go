@@ -0,0 +1,345 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// CachedQuerySettings are the cache tiers of CachedQuery, the local cache is tried first, then memcache
+type CachedQuerySettings struct {
+	LocalCache  string        // name of a registered LocalCache, empty to skip the tier
+	LocalTTL    time.Duration // bounded by the TTL of the local cache, default: the TTL of the local cache
+	Memcache    string        // name of a registered Memcache, empty to skip the tier
+	MemcacheTTL time.Duration // default 1h
+	NegativeTTL time.Duration // how long a sql.ErrNoRows of the loader is cached, 0 to not cache it
+}
+
+// cachedQueryValue is what the tiers store: the json of the loaded value, or the fact there was none
+type cachedQueryValue struct {
+	data      []byte
+	notFound  bool
+	expiresAt time.Time
+}
+
+// cachedQueryCall is a loader call shared by the concurrent calls of a key
+type cachedQueryCall struct {
+	done  chan struct{}
+	value cachedQueryValue
+	err   error
+}
+
+var cachedQueryCalls = make(map[string]*cachedQueryCall)
+var cachedQueryCallsMutex sync.Mutex
+
+// cachedQueryGeneration is the generation of a template, it is part of the keys so that changing it
+// invalidates every key of the template
+type cachedQueryGeneration struct {
+	value      string
+	fetchedAt  time.Time
+	refreshing bool // a call is reading it from memcache, the others keep the current one meanwhile
+}
+
+// cachedQueryGenerationRefresh is how often the generations are read again from memcache, the invalidations
+// of the other instances are seen after it
+const cachedQueryGenerationRefresh = time.Second
+
+var cachedQueryGenerations = make(map[string]cachedQueryGeneration)
+var cachedQueryGenerationsMutex sync.Mutex
+
+// CachedQuery fills dest with the value of a cache key (see GetCacheKey), it is read from the local cache, then from
+// memcache, then loaded with loader and stored in both. The values go through json, dest is a pointer to what loader returns.
+// The concurrent calls of a key share the same loader call. A loader returning sql.ErrNoRows is cached for
+// NegativeTTL, CachedQuery returns sql.ErrNoRows then
+// ex : var user User
+//
+//	err := wconnectors.CachedQuery(ctx, "user", map[string]string{"id": id}, settings, &user, func(ctx context.Context) (interface{}, error) {
+//		var user User
+//		err := wconnectors.Get(ctx, wconnectors.Db("main"), &user, "SELECT * FROM user WHERE id = ?", id)
+//		return user, err
+//	})
+func CachedQuery(ctx context.Context, template string, params map[string]string, settings CachedQuerySettings, dest interface{}, loader func(ctx context.Context) (interface{}, error)) error {
+	key, err := cachedQueryKey(template, params, settings)
+	if err != nil {
+		return err
+	}
+
+	value, ok := cachedQueryGetLocal(key, settings)
+	if !ok {
+		value, ok = cachedQueryGetMemcache(key, settings)
+		if ok {
+			cachedQuerySetLocal(key, value, settings)
+		}
+	}
+	if !ok {
+		value, err = cachedQueryLoad(ctx, key, settings, loader)
+		if err != nil {
+			return err
+		}
+	}
+
+	if value.notFound {
+		return sql.ErrNoRows
+	}
+	return json.Unmarshal(value.data, dest)
+}
+
+// InvalidateCachedQuery removes a key from the tiers of the settings. The local tier is the one of this instance only,
+// the other instances keep the key until their LocalTTL, InvalidateCachedQueries reaches them all
+func InvalidateCachedQuery(template string, params map[string]string, settings CachedQuerySettings) error {
+	key, err := cachedQueryKey(template, params, settings)
+	if err != nil {
+		return err
+	}
+	if settings.LocalCache != "" {
+		LocalCache(settings.LocalCache).Remove(key)
+	}
+	if settings.Memcache != "" {
+		if connection := Memcache(settings.Memcache); connection != nil {
+			if err := connection.Delete(key); err != nil && err != memcache.ErrCacheMiss {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InvalidateCachedQueries invalidates every key of a template, the other instances see it within a second
+// when memcache is used
+func InvalidateCachedQueries(template string, settings CachedQuerySettings) {
+	generation := cachedQueryGeneration{value: newCachedQueryGeneration(), fetchedAt: time.Now()}
+	if settings.Memcache != "" {
+		if connection := Memcache(settings.Memcache); connection != nil {
+			connection.Set(cachedQueryGenerationKey(template), []byte(generation.value), 0)
+		}
+	}
+	cachedQueryGenerationsMutex.Lock()
+	cachedQueryGenerations[template] = generation
+	cachedQueryGenerationsMutex.Unlock()
+}
+
+// cachedQueryKey returns the key of a template for the current generation of the template
+func cachedQueryKey(template string, params map[string]string, settings CachedQuerySettings) (string, error) {
+	key, ok := GetCacheKey(template, params)
+	if !ok {
+		return "", fmt.Errorf("wconnectors: cache key %q was not registered", template)
+	}
+	return key + ":g" + cachedQueryTemplateGeneration(template, settings), nil
+}
+
+// cachedQueryGenerationKey is the memcache key of the generation of a template
+func cachedQueryGenerationKey(template string) string {
+	return "wcq:gen:" + template
+}
+
+// newCachedQueryGeneration returns a generation that was not used before
+func newCachedQueryGeneration() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// cachedQueryTemplateGeneration returns the generation of a template, it is read again from memcache
+// every cachedQueryGenerationRefresh
+func cachedQueryTemplateGeneration(template string, settings CachedQuerySettings) string {
+	cachedQueryGenerationsMutex.Lock()
+	generation, ok := cachedQueryGenerations[template]
+	if ok && (settings.Memcache == "" || generation.refreshing || time.Since(generation.fetchedAt) < cachedQueryGenerationRefresh) {
+		cachedQueryGenerationsMutex.Unlock()
+		return generation.value
+	}
+	if ok {
+		generation.refreshing = true
+		cachedQueryGenerations[template] = generation
+	}
+	cachedQueryGenerationsMutex.Unlock()
+
+	// memcache is read without the mutex, the templates do not wait for one another
+	started := time.Now()
+	value, fetched := cachedQueryFetchGeneration(template, settings)
+	if !fetched {
+		// memcache cannot tell, we keep ours and try again after the refresh
+		value = generation.value
+		if !ok {
+			value = newCachedQueryGeneration()
+		}
+	}
+
+	cachedQueryGenerationsMutex.Lock()
+	defer cachedQueryGenerationsMutex.Unlock()
+	if current, ok := cachedQueryGenerations[template]; ok && current.fetchedAt.After(started) {
+		// invalidated or fetched by a concurrent call meanwhile
+		return current.value
+	}
+	cachedQueryGenerations[template] = cachedQueryGeneration{value: value, fetchedAt: time.Now()}
+	return value
+}
+
+// cachedQueryFetchGeneration reads the generation of a template from memcache, a missing generation is created
+// so that an evicted generation cannot bring back the keys it invalidated. It returns false when memcache
+// failed, a generation created then would invalidate the keys of every instance
+func cachedQueryFetchGeneration(template string, settings CachedQuerySettings) (string, bool) {
+	if settings.Memcache == "" {
+		return "0", true
+	}
+	connection := Memcache(settings.Memcache)
+	if connection == nil || connection.GetClient() == nil {
+		return "0", true
+	}
+
+	key := cachedQueryGenerationKey(template)
+	data, err := connection.Get(key)
+	if err == nil && len(data) > 0 {
+		return string(data), true
+	}
+	if err != nil && err != memcache.ErrCacheMiss {
+		return "", false
+	}
+	value := newCachedQueryGeneration()
+	switch err := connection.Add(key, []byte(value), 0); err {
+	case nil:
+		return value, true
+	case memcache.ErrNotStored:
+		// created by another instance meanwhile
+		if data, err := connection.Get(key); err == nil && len(data) > 0 {
+			return string(data), true
+		}
+	}
+	return "", false
+}
+
+// cachedQueryLoad calls the loader, once for the concurrent calls of a key, and fills the tiers
+func cachedQueryLoad(ctx context.Context, key string, settings CachedQuerySettings, loader func(ctx context.Context) (interface{}, error)) (cachedQueryValue, error) {
+	cachedQueryCallsMutex.Lock()
+	if call, ok := cachedQueryCalls[key]; ok {
+		cachedQueryCallsMutex.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.err
+		case <-ctx.Done():
+			return cachedQueryValue{}, ctx.Err()
+		}
+	}
+	call := &cachedQueryCall{done: make(chan struct{})}
+	cachedQueryCalls[key] = call
+	cachedQueryCallsMutex.Unlock()
+
+	defer func() {
+		cachedQueryCallsMutex.Lock()
+		delete(cachedQueryCalls, key)
+		cachedQueryCallsMutex.Unlock()
+		close(call.done)
+	}()
+
+	loaded, err := loader(ctx)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		call.value = cachedQueryValue{notFound: true}
+		if settings.NegativeTTL <= 0 {
+			return call.value, nil
+		}
+	case err != nil:
+		call.err = err
+		return call.value, err
+	default:
+		call.value.data, call.err = json.Marshal(loaded)
+		if call.err != nil {
+			call.err = fmt.Errorf("wconnectors: cached query %s: %w", key, call.err)
+			return call.value, call.err
+		}
+	}
+
+	cachedQuerySetLocal(key, call.value, settings)
+	cachedQuerySetMemcache(key, call.value, settings)
+	return call.value, nil
+}
+
+// cachedQueryTTL returns how long a value is kept by a tier
+func cachedQueryTTL(value cachedQueryValue, ttl time.Duration, settings CachedQuerySettings) time.Duration {
+	if value.notFound && (settings.NegativeTTL < ttl || ttl <= 0) {
+		return settings.NegativeTTL
+	}
+	return ttl
+}
+
+// cachedQueryGetLocal reads a key from the local cache
+func cachedQueryGetLocal(key string, settings CachedQuerySettings) (cachedQueryValue, bool) {
+	if settings.LocalCache == "" {
+		return cachedQueryValue{}, false
+	}
+	cached, ok := LocalCache(settings.LocalCache).Get(key)
+	if !ok {
+		return cachedQueryValue{}, false
+	}
+	value, ok := cached.(cachedQueryValue)
+	if !ok || (!value.expiresAt.IsZero() && time.Now().After(value.expiresAt)) {
+		return cachedQueryValue{}, false
+	}
+	return value, true
+}
+
+// cachedQuerySetLocal stores a key in the local cache
+func cachedQuerySetLocal(key string, value cachedQueryValue, settings CachedQuerySettings) {
+	if settings.LocalCache == "" {
+		return
+	}
+	if ttl := cachedQueryTTL(value, settings.LocalTTL, settings); ttl > 0 {
+		value.expiresAt = time.Now().Add(ttl)
+	} else {
+		value.expiresAt = time.Time{}
+	}
+	LocalCache(settings.LocalCache).Set(key, value)
+}
+
+// cachedQueryGetMemcache reads a key from memcache, the first byte tells whether there was a value
+func cachedQueryGetMemcache(key string, settings CachedQuerySettings) (cachedQueryValue, bool) {
+	if settings.Memcache == "" {
+		return cachedQueryValue{}, false
+	}
+	connection := Memcache(settings.Memcache)
+	if connection == nil {
+		return cachedQueryValue{}, false
+	}
+	data, err := connection.Get(key)
+	if err != nil || len(data) == 0 {
+		return cachedQueryValue{}, false
+	}
+	switch data[0] {
+	case 'v':
+		return cachedQueryValue{data: data[1:]}, true
+	case 'n':
+		return cachedQueryValue{notFound: true}, true
+	}
+	return cachedQueryValue{}, false
+}
+
+// cachedQuerySetMemcache stores a key in memcache
+func cachedQuerySetMemcache(key string, value cachedQueryValue, settings CachedQuerySettings) {
+	if settings.Memcache == "" {
+		return
+	}
+	connection := Memcache(settings.Memcache)
+	if connection == nil {
+		return
+	}
+	memcacheTTL := settings.MemcacheTTL
+	if memcacheTTL <= 0 {
+		memcacheTTL = time.Hour
+	}
+	ttl := cachedQueryTTL(value, memcacheTTL, settings)
+	// memcache counts in seconds, 0 would keep the key forever
+	seconds := int32(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	data := []byte{'n'}
+	if !value.notFound {
+		data = append([]byte{'v'}, value.data...)
+	}
+	connection.Set(key, data, seconds)
+}
@@ -0,0 +1,185 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/webediads/adsgolib/wlog"
+)
+
+type cachedUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// newCachedQuerySettings registers a local cache of its own for a test, without memcache
+func newCachedQuerySettings(name string, negativeTTL time.Duration) CachedQuerySettings {
+	RegisterLocalCache(name, LocalCacheSettings{Size: 100, TTL: 60})
+	RegisterCacheKeys(map[string]string{"cq_user": "user:[id]"})
+	return CachedQuerySettings{LocalCache: name, NegativeTTL: negativeTTL}
+}
+
+// countingLoader returns a loader counting its calls
+func countingLoader(calls *int32, value interface{}, err error) func(ctx context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(calls, 1)
+		return value, err
+	}
+}
+
+func TestCachedQuery(t *testing.T) {
+	ctx := context.Background()
+	settings := newCachedQuerySettings("cq_hit", 0)
+	params := map[string]string{"id": "1"}
+
+	var calls int32
+	loader := countingLoader(&calls, cachedUser{ID: 1, Name: "bob"}, nil)
+	for i := 0; i < 3; i++ {
+		var user cachedUser
+		if err := CachedQuery(ctx, "cq_user", params, settings, &user, loader); err != nil {
+			t.Fatal(err)
+		}
+		if user != (cachedUser{ID: 1, Name: "bob"}) {
+			t.Errorf("user = %+v, want bob", user)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader called %d times, want 1", calls)
+	}
+
+	// the errors of the loader are not cached
+	errFailed := errors.New("failed")
+	calls = 0
+	failing := countingLoader(&calls, nil, errFailed)
+	for i := 0; i < 2; i++ {
+		var user cachedUser
+		if err := CachedQuery(ctx, "cq_user", map[string]string{"id": "2"}, settings, &user, failing); err != errFailed {
+			t.Errorf("CachedQuery() = %v, want %v", err, errFailed)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("failing loader called %d times, want 2", calls)
+	}
+
+	if err := CachedQuery(ctx, "cq_missing", params, settings, new(cachedUser), loader); err == nil {
+		t.Error("CachedQuery() of an unregistered key = nil, want an error")
+	}
+}
+
+func TestCachedQueryNotFound(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name        string
+		negativeTTL time.Duration
+		wantCalls   int32
+	}{
+		{"cached", time.Hour, 1},
+		{"not cached", 0, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := newCachedQuerySettings("cq_not_found_"+test.name, test.negativeTTL)
+			var calls int32
+			loader := countingLoader(&calls, nil, sql.ErrNoRows)
+			for i := 0; i < 3; i++ {
+				if err := CachedQuery(ctx, "cq_user", map[string]string{"id": "1"}, settings, new(cachedUser), loader); err != sql.ErrNoRows {
+					t.Errorf("CachedQuery() = %v, want sql.ErrNoRows", err)
+				}
+			}
+			if calls != test.wantCalls {
+				t.Errorf("loader called %d times, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+
+	// a negative entry expires after NegativeTTL
+	settings := newCachedQuerySettings("cq_not_found_expired", 10*time.Millisecond)
+	var calls int32
+	loader := countingLoader(&calls, nil, sql.ErrNoRows)
+	CachedQuery(ctx, "cq_user", map[string]string{"id": "1"}, settings, new(cachedUser), loader)
+	time.Sleep(20 * time.Millisecond)
+	CachedQuery(ctx, "cq_user", map[string]string{"id": "1"}, settings, new(cachedUser), loader)
+	if calls != 2 {
+		t.Errorf("loader called %d times after NegativeTTL, want 2", calls)
+	}
+}
+
+func TestCachedQueryConcurrentCalls(t *testing.T) {
+	ctx := context.Background()
+	settings := newCachedQuerySettings("cq_concurrent", 0)
+
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		// long enough for every caller to wait for this call
+		time.Sleep(50 * time.Millisecond)
+		return cachedUser{ID: 1, Name: "bob"}, nil
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			var user cachedUser
+			if err := CachedQuery(ctx, "cq_user", map[string]string{"id": "1"}, settings, &user, loader); err != nil || user.Name != "bob" {
+				t.Errorf("CachedQuery() = %+v, %v", user, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader called %d times, want 1", calls)
+	}
+}
+
+func TestInvalidateCachedQuery(t *testing.T) {
+	ctx := context.Background()
+	settings := newCachedQuerySettings("cq_invalidate", 0)
+	bob := map[string]string{"id": "1"}
+	alice := map[string]string{"id": "2"}
+
+	var calls int32
+	loader := countingLoader(&calls, cachedUser{ID: 1, Name: "bob"}, nil)
+	load := func(params map[string]string) {
+		if err := CachedQuery(ctx, "cq_user", params, settings, new(cachedUser), loader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load(bob)
+	load(alice)
+
+	// only the key is invalidated
+	if err := InvalidateCachedQuery("cq_user", bob, settings); err != nil {
+		t.Fatal(err)
+	}
+	load(bob)
+	load(alice)
+	if calls != 3 {
+		t.Errorf("loader called %d times after InvalidateCachedQuery, want 3", calls)
+	}
+
+	// every key of the template is invalidated
+	InvalidateCachedQueries("cq_user", settings)
+	load(bob)
+	load(alice)
+	if calls != 5 {
+		t.Errorf("loader called %d times after InvalidateCachedQueries, want 5", calls)
+	}
+
+	// the failures of memcache are returned, and logged by the connection
+	wlog.SetLogger(wlog.NewConsole(), "wconnectors", "test")
+	RegisterMemcache("cq_down", "127.0.0.1:1")
+	settings.Memcache = "cq_down"
+	if err := InvalidateCachedQuery("cq_user", bob, settings); err == nil {
+		t.Error("InvalidateCachedQuery() with memcache down = nil, want an error")
+	}
+}
@@ -1,16 +1,18 @@
 package wconnectors
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"strings"
+	"log"
 	"sync"
 	"time"
 
 	// mysql
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/webediads/adsgolib/wconfig"
+	"github.com/webediads/adsgolib/wlog"
 )
 
 var dbConnections map[string]*sql.DB
@@ -23,23 +25,73 @@ type DbSettings struct {
 	Username string
 	Password string
 	Host     string
-	Port     string
-	Database string
+	Port     string // default 3306, 5432 with postgres
+	Database string // the path of the file with DbDriverSQLite
 	IsMock   bool
+	// DbDriverMySQL (default), DbDriverPostgres, DbDriverPgx or DbDriverSQLite, see DbDriverMySQL for the imports.
+	// The sql written by the package is the one of mysql: Named and In give ? placeholders (see Rebind),
+	// BulkWriter, wmigrate and MaxReplicaLag are only supported by mysql
+	Driver string
+
+	Socket    string            // unix socket path, replaces Host and Port, the directory of the socket with postgres
+	Charset   string            // ex: utf8mb4, or utf8mb4,utf8 to fall back on utf8
+	Collation string            // ex: utf8mb4_unicode_ci, default utf8mb4_general_ci
+	Loc       string            // time zone of the time.Time values, ex: Europe/Paris, default UTC
+	TLS       string            // true, false, skip-verify, preferred or a profile registered with mysql.RegisterTLSConfig
+	Params    map[string]string // other driver parameters or system variables, ex: sql_mode
+
+	MaxOpenConns    int           // default 100
+	MaxIdleConns    int           // default 2 (database/sql), negative for none
+	ConnMaxIdleTime time.Duration // 0 keeps the idle connections forever, requires go 1.15
+	ConnMaxLifetime time.Duration // default 1m
+	ConnectTimeout  time.Duration // 0 for the system default
+	ReadTimeout     time.Duration // 0 for none
+	WriteTimeout    time.Duration // 0 for none
+
+	Replicas             []string      // host:port of the replicas used by DbReader, they share the other settings
+	ReplicaBalancing     string        // DbBalanceRoundRobin (default) or DbBalanceLeastConns
+	MaxReplicaLag        time.Duration // replicas further behind are ejected, 0 disables the check (it requires the REPLICATION CLIENT privilege)
+	ReplicaCheckInterval time.Duration // default 10s
+
+	Instrument         bool          // counts the queries by normalized query, see DbQueryStats
+	SlowQueryThreshold time.Duration // logs the queries lasting longer as warnings, implies Instrument, 0 disables the log
+
+	BreakerThreshold int           // consecutive failures of the server opening the circuit breaker of the connection, 0 disables it, see Breakers
+	BreakerCooldown  time.Duration // how long the breaker stays open, default 10s
+
+	Fixture     string // fixture file of FixtureMode
+	FixtureMode string // DbFixtureRecord records the exchanges with the server into Fixture when the db is closed or by Shutdown, DbFixtureReplay answers from it without a server
 }
 
 var allDbSettings = make(map[string]DbSettings)
 
-// RegisterDb registers a db connection
-func RegisterDb(name string) {
-	allDbSettings[name] = DbSettings{
-		Username: wconfig.Config.GetUnsafe("db", name+".username"),
-		Password: wconfig.Config.GetUnsafe("db", name+".password"),
-		Host:     wconfig.Config.GetUnsafe("db", name+".host"),
-		Port:     wconfig.Config.GetUnsafe("db", name+".port"),
-		Database: wconfig.Config.GetUnsafe("db", name+".database"),
-		IsMock:   false,
+// RegisterDb registers a db connection from the [db] section of the config, the connection is not
+// registered if the settings are invalid
+func RegisterDb(name string) error {
+	settings, err := dbSettingsFromConfig(name)
+	if err != nil {
+		log.Println("RegisterDb " + name + ": " + err.Error())
+		return fmt.Errorf("wconnectors: db %s: %w", name, err)
 	}
+	return RegisterDbSettings(name, settings)
+}
+
+// RegisterDbSettings registers a db connection, the connection is not registered if the settings are invalid
+// ex : wconnectors.RegisterDbSettings("main", wconnectors.DbSettings{Username: "app", Host: "mysql", Database: "main", Charset: "utf8mb4"})
+func RegisterDbSettings(name string, settings DbSettings) error {
+	if err := settings.validate(); err != nil {
+		log.Println("RegisterDb " + name + ": " + err.Error())
+		return fmt.Errorf("wconnectors: db %s: %w", name, err)
+	}
+	allDbSettings[name] = settings
+
+	if dbFailFast {
+		if _, err := DbE(name); err != nil {
+			delete(allDbSettings, name)
+			return err
+		}
+	}
+	return nil
 }
 
 // RegisterMockDb registers a mocked db connection
@@ -54,60 +106,75 @@ func RegisterMockDb(name string) {
 	}
 }
 
-// Db returns a connection
+// Db returns a connection, it panics if the connection cannot be opened, see DbE
 func Db(name string) *sql.DB {
+	db, err := DbE(name)
+	if err != nil {
+		panic(err.Error())
+	}
+	return db
+}
+
+// DbE returns a connection, or an error if the name was not registered or if the connection cannot be opened.
+// The connection is pinged when it is opened, a failure is only logged unless SetDbFailFast was called
+func DbE(name string) (*sql.DB, error) {
 
 	if name == "" {
-		panic("DB name cannot be empty")
+		return nil, errors.New("DB name cannot be empty")
 	}
 
 	dbSettings, ok := allDbSettings[name]
 	if !ok {
-		panic("This DB '" + name + "' was not registered")
+		return nil, errors.New("This DB '" + name + "' was not registered")
 	}
 
+	dbOnceMutex.Lock()
+	defer dbOnceMutex.Unlock()
+
 	if len(dbOnce) == 0 {
 		dbOnce = make(map[string]bool, 15)
 		dbConnections = make(map[string]*sql.DB, 15)
 		dbMocks = make(map[string]sqlmock.Sqlmock, 15)
 	}
 
-	dbOnceMutex.Lock()
 	if !dbOnce[name] {
-		dbOnce[name] = true
-		if !dbSettings.IsMock {
-			connectionStringArr := []string{
-				dbSettings.Username,
-				":",
-				dbSettings.Password,
-				"@(",
-				dbSettings.Host,
-				":",
-				dbSettings.Port,
-				")/",
-				dbSettings.Database,
-				"?parseTime=true",
+		var db *sql.DB
+		var err error
+		if dbSettings.FixtureMode == DbFixtureReplay {
+			db, err = openDbReplay(name, dbSettings.Fixture)
+			if err != nil {
+				return nil, fmt.Errorf("wconnectors: db %s: %w", name, err)
 			}
-			dbConnections[name], _ = sql.Open("mysql", strings.Join(connectionStringArr, ""))
-			err := dbConnections[name].Ping()
+		} else if !dbSettings.IsMock {
+			db, err = openDb(name, dbSettings, dbSettings.breaker("db "+name))
 			if err != nil {
-				fmt.Println(err.Error())
+				return nil, fmt.Errorf("wconnectors: db %s: %w", name, err)
 			}
-			dbConnections[name].SetConnMaxLifetime(time.Minute)
-			dbConnections[name].SetMaxOpenConns(100)
 		} else {
-			dbConnections[name], dbMocks[name], _ = sqlmock.New()
-			err := dbConnections[name].Ping()
+			db, dbMocks[name], err = sqlmock.New()
 			if err != nil {
-				fmt.Println(err.Error())
+				return nil, fmt.Errorf("wconnectors: db %s: %w", name, err)
 			}
 		}
-		dbOnceMutex.Unlock()
-	} else {
-		dbOnceMutex.Unlock()
+
+		if err := pingDb(context.Background(), db, dbSettings); err != nil {
+			if dbFailFast {
+				// the next call tries again
+				db.Close()
+				return nil, fmt.Errorf("wconnectors: db %s: %w", name, err)
+			}
+			wlog.LogError(wlog.LevelError, wlog.Wrap(err, "db "+name), wlog.Field("db", name))
+		}
+
+		dbOnce[name] = true
+		dbConnections[name] = db
+		// the reads of the replicas were recorded along with the ones of the primary
+		if len(dbSettings.Replicas) > 0 && dbSettings.FixtureMode != DbFixtureReplay {
+			dbReplicaSets[name] = openDbReplicas(name, dbSettings)
+		}
 	}
 
-	return dbConnections[name]
+	return dbConnections[name], nil
 
 }
 
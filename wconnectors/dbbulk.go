@@ -0,0 +1,321 @@
+package wconnectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// default bulk writer settings
+const (
+	defaultBulkMaxRows         = 1000
+	defaultBulkMaxPlaceholders = 65535 // the limit of a prepared statement
+	defaultBulkMaxPacketSize   = 4 << 20
+	defaultBulkFlushInterval   = 5 * time.Second
+	// room for the headers of the packet and the rounding of the estimates
+	bulkPacketMargin = 1024
+)
+
+// BulkWriterSettings is the struct that is used for configuring a BulkWriter
+type BulkWriterSettings struct {
+	MaxRows              int                     // rows per statement, default 1000
+	MaxPlaceholders      int                     // ? per statement, default 65535
+	MaxPacketSize        int                     // estimated bytes per statement, keep it under max_allowed_packet, default 4MB
+	FlushInterval        time.Duration           // the pending rows are written at least this often, default 5s, negative to only flush by size
+	Ignore               bool                    // INSERT IGNORE, the rows breaking a unique key are skipped
+	OnDuplicateKeyUpdate []string                // columns updated with the new values when a row breaks a unique key
+	OnBatch              func(result BulkResult) // called after each statement, the errors of the background flushes are logged if it is nil
+}
+
+// BulkResult is the outcome of a statement of a BulkWriter
+type BulkResult struct {
+	Rows         int   // rows sent
+	RowsAffected int64 // 1 per inserted row, 2 per updated row with OnDuplicateKeyUpdate
+	Duration     time.Duration
+	Err          error // the rows of the statement are lost if it is set
+}
+
+// BulkWriter writes rows to a table with multi-row INSERT statements
+type BulkWriter struct {
+	dbName    string
+	table     string
+	columns   []string
+	settings  BulkWriterSettings
+	mutex     sync.Mutex
+	pending   []interface{} // values of the pending rows, one after the other
+	closed    bool
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// ErrBulkWriterClosed is returned by the rows added once the writer is closed
+var ErrBulkWriterClosed = errors.New("wconnectors: bulk writer is closed")
+
+// the writers that are not closed yet, Shutdown closes them
+var bulkWriters = make(map[*BulkWriter]bool)
+var bulkWritersMutex sync.Mutex
+
+// NewBulkWriter will instantiate a writer of the columns of a table of a registered connection
+// ex : writer, err := wconnectors.NewBulkWriter("stats", "ad_event", []string{"ad_id", "day", "views"}, wconnectors.BulkWriterSettings{OnDuplicateKeyUpdate: []string{"views"}})
+//
+//	writer.Add(ctx, adID, day, views)
+//	defer writer.Close(ctx)
+func NewBulkWriter(dbName string, table string, columns []string, settingsOpt ...BulkWriterSettings) (*BulkWriter, error) {
+	var settings BulkWriterSettings
+	if len(settingsOpt) > 0 {
+		settings = settingsOpt[0]
+	}
+	if settings.MaxRows <= 0 {
+		settings.MaxRows = defaultBulkMaxRows
+	}
+	if settings.MaxPlaceholders <= 0 {
+		settings.MaxPlaceholders = defaultBulkMaxPlaceholders
+	}
+	if settings.MaxPacketSize <= 0 {
+		settings.MaxPacketSize = defaultBulkMaxPacketSize
+	}
+	if settings.FlushInterval == 0 {
+		settings.FlushInterval = defaultBulkFlushInterval
+	}
+
+	if table == "" || len(columns) == 0 {
+		return nil, errors.New("wconnectors: a bulk writer needs a table and columns")
+	}
+	if len(columns) > settings.MaxPlaceholders {
+		return nil, fmt.Errorf("wconnectors: %d columns are above the %d placeholders of a statement", len(columns), settings.MaxPlaceholders)
+	}
+	if settings.Ignore && len(settings.OnDuplicateKeyUpdate) > 0 {
+		return nil, errors.New("wconnectors: a bulk writer cannot both ignore and update the duplicates")
+	}
+	if _, ok := allDbSettings[dbName]; !ok {
+		return nil, errors.New("This DB '" + dbName + "' was not registered")
+	}
+	if driver := DbDriver(dbName); driver != DbDriverMySQL {
+		return nil, fmt.Errorf("wconnectors: the bulk writers write mysql statements, the %s driver of db %s is not supported", driver, dbName)
+	}
+
+	writer := &BulkWriter{
+		dbName:   dbName,
+		table:    table,
+		columns:  append([]string(nil), columns...),
+		settings: settings,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if settings.FlushInterval > 0 {
+		go writer.run()
+	} else {
+		close(writer.stopped)
+	}
+	bulkWritersMutex.Lock()
+	bulkWriters[writer] = true
+	bulkWritersMutex.Unlock()
+	return writer, nil
+}
+
+// Add adds a row, its values in the order of the columns, the []byte are copied so that the caller can reuse them.
+// The full statements are written right away, Add waits for them. It returns ErrBulkWriterClosed once Close was called
+func (writer *BulkWriter) Add(ctx context.Context, values ...interface{}) error {
+	if len(values) != len(writer.columns) {
+		return fmt.Errorf("wconnectors: %d values for the %d columns of %s", len(values), len(writer.columns), writer.table)
+	}
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.closed {
+		return fmt.Errorf("%w: %s", ErrBulkWriterClosed, writer.table)
+	}
+	for _, value := range values {
+		if bytes, ok := value.([]byte); ok && bytes != nil {
+			// an empty []byte stays empty, a nil one would be written as NULL
+			copied := make([]byte, len(bytes))
+			copy(copied, bytes)
+			value = copied
+		}
+		writer.pending = append(writer.pending, value)
+	}
+	if len(writer.pending)/len(writer.columns) < writer.maxRows() {
+		return nil
+	}
+	return writer.flush(ctx, false)
+}
+
+// Flush writes the pending rows, it returns the first error of the statements, see OnBatch for all of them
+func (writer *BulkWriter) Flush(ctx context.Context) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.flush(ctx, true)
+}
+
+// Close stops the background flushes and writes the pending rows, the rows added afterwards are rejected
+func (writer *BulkWriter) Close(ctx context.Context) error {
+	writer.mutex.Lock()
+	writer.closed = true
+	writer.mutex.Unlock()
+	writer.closeOnce.Do(func() {
+		if writer.settings.FlushInterval > 0 {
+			close(writer.done)
+		}
+	})
+	<-writer.stopped
+	bulkWritersMutex.Lock()
+	delete(bulkWriters, writer)
+	bulkWritersMutex.Unlock()
+	return writer.Flush(ctx)
+}
+
+// run flushes the pending rows every FlushInterval
+func (writer *BulkWriter) run() {
+	defer close(writer.stopped)
+	ticker := time.NewTicker(writer.settings.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			writer.mutex.Lock()
+			err := writer.flush(context.Background(), true)
+			writer.mutex.Unlock()
+			if err != nil && writer.settings.OnBatch == nil {
+				wlog.LogError(wlog.LevelError, wlog.Wrap(err, "bulk writer "+writer.table), wlog.Field("db", writer.dbName))
+			}
+		case <-writer.done:
+			return
+		}
+	}
+}
+
+// maxRows returns the rows per statement allowed by MaxRows and MaxPlaceholders
+func (writer *BulkWriter) maxRows() int {
+	maxRows := writer.settings.MaxPlaceholders / len(writer.columns)
+	if writer.settings.MaxRows < maxRows {
+		maxRows = writer.settings.MaxRows
+	}
+	return maxRows
+}
+
+// flush writes the pending rows in statements, the last partial statement is kept for later unless all is set,
+// the mutex must be held
+func (writer *BulkWriter) flush(ctx context.Context, all bool) error {
+	if len(writer.pending) == 0 {
+		return nil
+	}
+	db, err := DbE(writer.dbName)
+	if err != nil {
+		return err
+	}
+
+	columnCount := len(writer.columns)
+	maxRows := writer.maxRows()
+	var firstErr error
+	for len(writer.pending) > 0 {
+		rows := 0
+		size := len(writer.statement(0)) + bulkPacketMargin
+		for rows < maxRows && rows*columnCount < len(writer.pending) {
+			rowSize := bulkRowSize(writer.pending[rows*columnCount : (rows+1)*columnCount])
+			// a single row above the limit is sent alone, the server tells if it is too big
+			if rows > 0 && size+rowSize > writer.settings.MaxPacketSize {
+				break
+			}
+			size += rowSize
+			rows++
+		}
+		full := rows == maxRows || rows*columnCount < len(writer.pending)
+		if !full && !all {
+			break
+		}
+
+		values := writer.pending[:rows*columnCount]
+		result := BulkResult{Rows: rows}
+		start := time.Now()
+		sqlResult, err := db.ExecContext(ctx, writer.statement(rows), values...)
+		result.Duration = time.Since(start)
+		if err == nil {
+			result.RowsAffected, _ = sqlResult.RowsAffected()
+		} else {
+			result.Err = fmt.Errorf("wconnectors: bulk insert of %d rows into %s: %w", rows, writer.table, err)
+			if firstErr == nil {
+				firstErr = result.Err
+			}
+		}
+		writer.pending = writer.pending[rows*columnCount:]
+		if writer.settings.OnBatch != nil {
+			writer.settings.OnBatch(result)
+		}
+	}
+	if len(writer.pending) == 0 {
+		// let the memory of the big batches go
+		writer.pending = nil
+	}
+	return firstErr
+}
+
+// statement returns the INSERT of a number of rows
+func (writer *BulkWriter) statement(rows int) string {
+	var builder strings.Builder
+	builder.WriteString("INSERT ")
+	if writer.settings.Ignore {
+		builder.WriteString("IGNORE ")
+	}
+	builder.WriteString("INTO " + quoteIdentifier(writer.table) + " (")
+	for i, column := range writer.columns {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(quoteIdentifier(column))
+	}
+	builder.WriteString(") VALUES ")
+
+	row := "(" + strings.Repeat("?, ", len(writer.columns)-1) + "?)"
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(row)
+	}
+
+	if len(writer.settings.OnDuplicateKeyUpdate) > 0 {
+		builder.WriteString(" ON DUPLICATE KEY UPDATE ")
+		for i, column := range writer.settings.OnDuplicateKeyUpdate {
+			if i > 0 {
+				builder.WriteString(", ")
+			}
+			quoted := quoteIdentifier(column)
+			builder.WriteString(quoted + " = VALUES(" + quoted + ")")
+		}
+	}
+	return builder.String()
+}
+
+// quoteIdentifier quotes a column or a table, ex: stats.ad_event gives `stats`.`ad_event`
+func quoteIdentifier(identifier string) string {
+	parts := strings.Split(identifier, ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.Replace(part, "`", "``", -1) + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+// bulkRowSize estimates the bytes of a row in the statement and its args
+func bulkRowSize(values []interface{}) int {
+	// the placeholders and the separators
+	size := 3 * len(values)
+	for _, value := range values {
+		switch typedValue := value.(type) {
+		case string:
+			size += len(typedValue) + 9
+		case []byte:
+			size += len(typedValue) + 9
+		case nil:
+			size++
+		default:
+			// the numbers, the dates and the booleans fit in a few bytes
+			size += 12
+		}
+	}
+	return size
+}
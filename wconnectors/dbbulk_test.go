@@ -0,0 +1,211 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBulkWriterStatement(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    string
+		settings BulkWriterSettings
+		rows     int
+		want     string
+	}{
+		{"insert", "ad_event", BulkWriterSettings{}, 2, "INSERT INTO `ad_event` (`ad_id`, `views`) VALUES (?, ?), (?, ?)"},
+		{"ignore", "stats.ad_event", BulkWriterSettings{Ignore: true}, 1, "INSERT IGNORE INTO `stats`.`ad_event` (`ad_id`, `views`) VALUES (?, ?)"},
+		{"upsert", "ad_event", BulkWriterSettings{OnDuplicateKeyUpdate: []string{"views"}}, 1, "INSERT INTO `ad_event` (`ad_id`, `views`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `views` = VALUES(`views`)"},
+	}
+	for _, test := range tests {
+		writer := &BulkWriter{table: test.table, columns: []string{"ad_id", "views"}, settings: test.settings}
+		if got := writer.statement(test.rows); got != test.want {
+			t.Errorf("%s: statement() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBulkWriterAddAfterClose(t *testing.T) {
+	RegisterMockDb("bulk_closed")
+	writer, err := NewBulkWriter("bulk_closed", "ad_event", []string{"ad_id", "views"}, BulkWriterSettings{FlushInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Add(context.Background(), 1, 10); !errors.Is(err, ErrBulkWriterClosed) {
+		t.Errorf("Add() after Close() = %v, want ErrBulkWriterClosed", err)
+	}
+}
+
+// newBulkMock returns a bulk writer on a mocked db, flushed by size only, and the results of its statements
+func newBulkMock(t *testing.T, name string, columns []string, settings BulkWriterSettings) (*BulkWriter, sqlmock.Sqlmock, *[]BulkResult) {
+	RegisterMockDb(name)
+	if _, err := DbE(name); err != nil {
+		t.Fatal(err)
+	}
+	results := new([]BulkResult)
+	settings.FlushInterval = -1
+	settings.OnBatch = func(result BulkResult) {
+		*results = append(*results, result)
+	}
+	writer, err := NewBulkWriter(name, "ad_event", columns, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writer, DbMock(name), results
+}
+
+// expectBulkInsert expects the insert of rows, their values one after the other
+func expectBulkInsert(mock sqlmock.Sqlmock, writer *BulkWriter, values ...driver.Value) *sqlmock.ExpectedExec {
+	rows := len(values) / len(writer.columns)
+	return mock.ExpectExec("^" + regexp.QuoteMeta(writer.statement(rows)) + "$").WithArgs(values...)
+}
+
+// bulkRows returns the rows of the results
+func bulkRows(results []BulkResult) []int {
+	var rows []int
+	for _, result := range results {
+		rows = append(rows, result.Rows)
+	}
+	return rows
+}
+
+func TestBulkWriterBatches(t *testing.T) {
+	ctx := context.Background()
+	title := strings.Repeat("t", 100)
+	// the size of a row of an id and a title, see bulkRowSize
+	rowSize := 2*3 + 12 + len(title) + 9
+
+	tests := []struct {
+		name     string
+		settings BulkWriterSettings
+		columns  int
+		rows     int
+		added    []int // the statements written by Add
+		flushed  []int // the statements written by Flush
+	}{
+		{"max rows", BulkWriterSettings{MaxRows: 2}, 2, 5, []int{2, 2}, []int{1}},
+		{"max placeholders", BulkWriterSettings{MaxPlaceholders: 7}, 3, 5, []int{2, 2}, []int{1}},
+		{"max packet size", BulkWriterSettings{MaxPacketSize: 2 * rowSize}, 2, 5, nil, []int{2, 2, 1}},
+		{"a row above the packet size goes alone", BulkWriterSettings{MaxPacketSize: 1}, 2, 2, nil, []int{1, 1}},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			columns := []string{"ad_id", "title", "views"}[:test.columns]
+			if test.settings.MaxPacketSize > 1 {
+				writer := &BulkWriter{table: "ad_event", columns: columns}
+				test.settings.MaxPacketSize += len(writer.statement(0)) + bulkPacketMargin
+			}
+			writer, mock, results := newBulkMock(t, "bulk_batches_"+strconv.Itoa(i), columns, test.settings)
+
+			var values []driver.Value
+			for row := 0; row < test.rows; row++ {
+				rowValues := []driver.Value{int64(row), title, int64(row * 10)}[:test.columns]
+				values = append(values, rowValues...)
+			}
+			expect := func(statements []int) {
+				for _, rows := range statements {
+					expectBulkInsert(mock, writer, values[:rows*test.columns]...).WillReturnResult(sqlmock.NewResult(0, int64(rows)))
+					values = values[rows*test.columns:]
+				}
+			}
+
+			expect(test.added)
+			for row := 0; row < test.rows; row++ {
+				rowValues := []interface{}{int64(row), title, int64(row * 10)}[:test.columns]
+				if err := writer.Add(ctx, rowValues...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the partial statement is kept for later
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(bulkRows(*results), test.added) {
+				t.Errorf("statements of Add = %v, want %v", bulkRows(*results), test.added)
+			}
+
+			expect(test.flushed)
+			*results = nil
+			if err := writer.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(bulkRows(*results), test.flushed) {
+				t.Errorf("statements of Flush = %v, want %v", bulkRows(*results), test.flushed)
+			}
+		})
+	}
+}
+
+func TestBulkWriterResults(t *testing.T) {
+	ctx := context.Background()
+	// a statement per row
+	settings := BulkWriterSettings{MaxPacketSize: 1, OnDuplicateKeyUpdate: []string{"views"}}
+	writer, mock, results := newBulkMock(t, "bulk_results", []string{"ad_id", "views"}, settings)
+	defer writer.Close(ctx)
+	errFailed := errors.New("lost connection")
+
+	rows := [][]driver.Value{{int64(1), int64(10)}, {int64(2), int64(20)}, {int64(1), int64(11)}}
+	expectBulkInsert(mock, writer, rows[0]...).WillReturnError(errFailed)
+	// 1 per inserted row, 2 per updated row
+	expectBulkInsert(mock, writer, rows[1]...).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectBulkInsert(mock, writer, rows[2]...).WillReturnResult(sqlmock.NewResult(0, 2))
+	for _, row := range rows {
+		if err := writer.Add(ctx, row[0], row[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the statements after a failure are still written
+	if err := writer.Flush(ctx); !errors.Is(err, errFailed) {
+		t.Errorf("Flush() = %v, want the error of the first statement", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(*results) != 3 {
+		t.Fatalf("%d results, want one per statement", len(*results))
+	}
+	for i, want := range []BulkResult{{Rows: 1, Err: errFailed}, {Rows: 1, RowsAffected: 1}, {Rows: 1, RowsAffected: 2}} {
+		got := (*results)[i]
+		if got.Rows != want.Rows || got.RowsAffected != want.RowsAffected || !errors.Is(got.Err, want.Err) || (want.Err == nil) != (got.Err == nil) {
+			t.Errorf("result %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestBulkWriterCopiesBytes(t *testing.T) {
+	ctx := context.Background()
+	writer, mock, _ := newBulkMock(t, "bulk_bytes", []string{"ad_id", "payload"}, BulkWriterSettings{})
+	defer writer.Close(ctx)
+
+	buffer := []byte("first")
+	if err := writer.Add(ctx, 1, buffer); err != nil {
+		t.Fatal(err)
+	}
+	copy(buffer, "reuse")
+	if err := writer.Add(ctx, 2, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectBulkInsert(mock, writer, int64(1), []byte("first"), int64(2), []byte{}).WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := writer.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
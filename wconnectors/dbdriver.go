@@ -0,0 +1,400 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// dbConnector wraps the connector of a driver so that the queries can be observed, and go through the breaker
+// of the connection if it has one
+type dbConnector struct {
+	connector driver.Connector
+	observer  *dbObserver
+	breaker   *Breaker
+}
+
+// Connect opens a connection with the wrapped connector
+func (connector *dbConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := connector.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	conn, err := connector.connector.Connect(ctx)
+	connector.breaker.Done(err)
+	if err != nil {
+		return nil, err
+	}
+	return &dbConn{conn: conn, observer: connector.observer, breaker: connector.breaker}, nil
+}
+
+// Driver returns the wrapped driver
+func (connector *dbConnector) Driver() driver.Driver {
+	return connector.connector.Driver()
+}
+
+// Close writes the recorded exchanges and closes the wrapped connector if it can be, database/sql calls it
+// when the db is closed since go 1.17
+func (connector *dbConnector) Close() error {
+	var err error
+	if connector.observer.recorder != nil {
+		err = connector.observer.recorder.flush()
+	}
+	if closer, ok := connector.connector.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// dbConn wraps a driver connection, the optional interfaces of the driver are forwarded
+// and database/sql falls back on its default behaviour when the driver does not implement them
+type dbConn struct {
+	conn     driver.Conn
+	observer *dbObserver
+	breaker  *Breaker
+}
+
+// Prepare prepares a statement
+func (conn *dbConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement, its executions are observed
+func (conn *dbConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := conn.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := conn.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = conn.conn.Prepare(query)
+	}
+	conn.breaker.Done(err)
+	if err != nil {
+		return nil, err
+	}
+	return &dbStmt{stmt: stmt, query: query, observer: conn.observer, breaker: conn.breaker}, nil
+}
+
+// Close closes the connection
+func (conn *dbConn) Close() error {
+	return conn.conn.Close()
+}
+
+// Begin starts a transaction
+func (conn *dbConn) Begin() (driver.Tx, error) {
+	return conn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction
+func (conn *dbConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	beginner, ok := conn.conn.(driver.ConnBeginTx)
+	if !ok && (opts.Isolation != 0 || opts.ReadOnly) {
+		return nil, errors.New("the driver does not support the transaction options")
+	}
+	if err := conn.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	var tx driver.Tx
+	var err error
+	if ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = conn.conn.Begin()
+	}
+	conn.breaker.Done(err)
+	return tx, err
+}
+
+// ExecContext executes a query without preparing it, when the driver supports it
+func (conn *dbConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := conn.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := conn.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	conn.breaker.Done(err)
+	if err == driver.ErrSkip {
+		// database/sql prepares the statement instead, it is observed then
+		return nil, err
+	}
+	conn.observer.observeResult(query, args, start, result, err)
+	return result, err
+}
+
+// QueryContext executes a query without preparing it, when the driver supports it
+func (conn *dbConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := conn.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := conn.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	conn.breaker.Done(err)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	return conn.observer.observeRows(query, args, start, rows, err)
+}
+
+// Ping checks the connection, when the driver supports it
+func (conn *dbConn) Ping(ctx context.Context) error {
+	pinger, ok := conn.conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	// the pings of the health checks are the first calls let through once the cooldown is over
+	if err := conn.breaker.Allow(); err != nil {
+		return err
+	}
+	err := pinger.Ping(ctx)
+	conn.breaker.Done(err)
+	return err
+}
+
+// ResetSession resets the connection before it is reused, when the driver supports it
+func (conn *dbConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := conn.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+// CheckNamedValue converts the arguments the way the driver does
+func (conn *dbConn) CheckNamedValue(namedValue *driver.NamedValue) error {
+	if checker, ok := conn.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(namedValue)
+	}
+	return driver.ErrSkip
+}
+
+// dbStmt wraps a prepared statement
+type dbStmt struct {
+	stmt     driver.Stmt
+	query    string
+	observer *dbObserver
+	breaker  *Breaker
+}
+
+// Close closes the statement
+func (stmt *dbStmt) Close() error {
+	return stmt.stmt.Close()
+}
+
+// NumInput returns the number of placeholders
+func (stmt *dbStmt) NumInput() int {
+	return stmt.stmt.NumInput()
+}
+
+// Exec executes the statement
+func (stmt *dbStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := stmt.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	result, err := stmt.stmt.Exec(args)
+	stmt.breaker.Done(err)
+	stmt.observer.observeResult(stmt.query, valuesToNamedValues(args), start, result, err)
+	return result, err
+}
+
+// Query executes the statement
+func (stmt *dbStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := stmt.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	rows, err := stmt.stmt.Query(args)
+	stmt.breaker.Done(err)
+	return stmt.observer.observeRows(stmt.query, valuesToNamedValues(args), start, rows, err)
+}
+
+// ExecContext executes the statement
+func (stmt *dbStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := stmt.stmt.(driver.StmtExecContext)
+	if !ok {
+		return stmt.Exec(namedValuesToValues(args))
+	}
+	if err := stmt.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, args)
+	stmt.breaker.Done(err)
+	stmt.observer.observeResult(stmt.query, args, start, result, err)
+	return result, err
+}
+
+// QueryContext executes the statement
+func (stmt *dbStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := stmt.stmt.(driver.StmtQueryContext)
+	if !ok {
+		return stmt.Query(namedValuesToValues(args))
+	}
+	if err := stmt.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, args)
+	stmt.breaker.Done(err)
+	return stmt.observer.observeRows(stmt.query, args, start, rows, err)
+}
+
+// CheckNamedValue converts the arguments the way the driver does
+func (stmt *dbStmt) CheckNamedValue(namedValue *driver.NamedValue) error {
+	if checker, ok := stmt.stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(namedValue)
+	}
+	return driver.ErrSkip
+}
+
+// namedValuesToValues drops the names of the arguments for the drivers that do not support the contexts
+func namedValuesToValues(namedValues []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(namedValues))
+	for i, namedValue := range namedValues {
+		values[i] = namedValue.Value
+	}
+	return values
+}
+
+// valuesToNamedValues numbers the arguments of the drivers that do not support the contexts
+func valuesToNamedValues(values []driver.Value) []driver.NamedValue {
+	namedValues := make([]driver.NamedValue, len(values))
+	for i, value := range values {
+		namedValues[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+	return namedValues
+}
+
+// dbRows wraps a result set, the query is observed once it is closed so that the rows are counted
+type dbRows struct {
+	rows     driver.Rows
+	query    string
+	args     []driver.NamedValue
+	start    time.Time
+	count    int64
+	values   [][]driver.Value // kept when the exchanges are recorded
+	err      error
+	eof      bool
+	closed   bool
+	observer *dbObserver
+}
+
+// Columns returns the names of the columns
+func (rows *dbRows) Columns() []string {
+	return rows.rows.Columns()
+}
+
+// Close closes the result set and observes the query
+func (rows *dbRows) Close() error {
+	if rows.closed {
+		return rows.rows.Close()
+	}
+	rows.closed = true
+	if rows.observer.recorder != nil && rows.err == nil && !rows.eof {
+		// the rows that were not read are recorded too, the same query reading them all is replayed in full
+		dest := make([]driver.Value, len(rows.rows.Columns()))
+		for {
+			err := rows.rows.Next(dest)
+			if err != nil {
+				if err != io.EOF {
+					rows.err = err
+				}
+				break
+			}
+			rows.values = append(rows.values, copyValues(dest))
+		}
+	}
+	err := rows.rows.Close()
+	rows.observer.observe(rows.query, time.Since(rows.start), rows.count, rows.err)
+	if rows.observer.recorder != nil {
+		rows.observer.recorder.recordRows(rows.query, rows.args, rows.rows.Columns(), rows.values, rows.err)
+	}
+	return err
+}
+
+// Next reads the next row
+func (rows *dbRows) Next(dest []driver.Value) error {
+	err := rows.rows.Next(dest)
+	if err == nil {
+		rows.count++
+		if rows.observer.recorder != nil {
+			// the driver reuses its buffers
+			rows.values = append(rows.values, copyValues(dest))
+		}
+	} else if err == io.EOF {
+		rows.eof = true
+	} else {
+		rows.err = err
+	}
+	return err
+}
+
+// HasNextResultSet tells whether there is another result set
+func (rows *dbRows) HasNextResultSet() bool {
+	if nextResultSet, ok := rows.rows.(driver.RowsNextResultSet); ok {
+		return nextResultSet.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet moves to the next result set
+func (rows *dbRows) NextResultSet() error {
+	if nextResultSet, ok := rows.rows.(driver.RowsNextResultSet); ok {
+		return nextResultSet.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType returns the go type of a column
+func (rows *dbRows) ColumnTypeScanType(index int) reflect.Type {
+	if columnType, ok := rows.rows.(driver.RowsColumnTypeScanType); ok {
+		return columnType.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName returns the database type of a column
+func (rows *dbRows) ColumnTypeDatabaseTypeName(index int) string {
+	if columnType, ok := rows.rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return columnType.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength returns the length of a column
+func (rows *dbRows) ColumnTypeLength(index int) (int64, bool) {
+	if columnType, ok := rows.rows.(driver.RowsColumnTypeLength); ok {
+		return columnType.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable tells whether a column is nullable
+func (rows *dbRows) ColumnTypeNullable(index int) (bool, bool) {
+	if columnType, ok := rows.rows.(driver.RowsColumnTypeNullable); ok {
+		return columnType.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale returns the precision and the scale of a decimal column
+func (rows *dbRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if columnType, ok := rows.rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return columnType.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
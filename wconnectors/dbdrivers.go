@@ -0,0 +1,230 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// the drivers of DbSettings, the application imports the ones it uses except mysql,
+// ex: import _ "github.com/lib/pq", _ "github.com/jackc/pgx/v4/stdlib" or _ "modernc.org/sqlite"
+const (
+	DbDriverMySQL    = "mysql"
+	DbDriverPostgres = "postgres" // github.com/lib/pq
+	DbDriverPgx      = "pgx"      // github.com/jackc/pgx/v4/stdlib
+	DbDriverSQLite   = "sqlite"   // modernc.org/sqlite, Database is the path of the file or :memory:
+)
+
+// the default ports of the servers
+var defaultDbPorts = map[string]string{
+	DbDriverMySQL:    "3306",
+	DbDriverPostgres: "5432",
+	DbDriverPgx:      "5432",
+}
+
+// the sslmode of the TLS settings of postgres
+var postgresSSLModes = map[string]string{
+	"true":        "verify-full",
+	"skip-verify": "require",
+	"preferred":   "prefer",
+	"false":       "disable",
+}
+
+// isPostgres tells whether the driver of the settings is a postgres one
+func (settings *DbSettings) isPostgres() bool {
+	return settings.Driver == DbDriverPostgres || settings.Driver == DbDriverPgx
+}
+
+// validateDriver checks the driver and the settings it does not support, the driver must be registered
+func (settings *DbSettings) validateDriver() error {
+	if settings.Driver == "" {
+		settings.Driver = DbDriverMySQL
+	}
+	switch settings.Driver {
+	case DbDriverMySQL, DbDriverPostgres, DbDriverPgx, DbDriverSQLite:
+	default:
+		return fmt.Errorf("invalid driver %q, %s, %s, %s or %s expected", settings.Driver, DbDriverMySQL, DbDriverPostgres, DbDriverPgx, DbDriverSQLite)
+	}
+	registered := false
+	for _, name := range sql.Drivers() {
+		registered = registered || name == settings.Driver
+	}
+	if !registered {
+		return fmt.Errorf("the %s driver is not registered, the application must import it", settings.Driver)
+	}
+	if settings.Driver == DbDriverMySQL {
+		return nil
+	}
+
+	unsupported := []struct {
+		name string
+		set  bool
+	}{
+		{"collation", settings.Collation != ""},
+		{"read timeout", settings.ReadTimeout != 0},
+		{"write timeout", settings.WriteTimeout != 0},
+		{"max replica lag (SHOW SLAVE STATUS)", settings.MaxReplicaLag != 0},
+	}
+	if settings.Driver == DbDriverSQLite {
+		// there is no server
+		unsupported = append(unsupported, []struct {
+			name string
+			set  bool
+		}{
+			{"username", settings.Username != ""},
+			{"host", settings.Host != ""},
+			{"port", settings.Port != ""},
+			{"socket", settings.Socket != ""},
+			{"charset", settings.Charset != ""},
+			{"loc", settings.Loc != ""},
+			{"tls", settings.TLS != ""},
+			{"connect timeout", settings.ConnectTimeout != 0},
+			{"replicas", len(settings.Replicas) > 0},
+		}...)
+	} else if _, ok := postgresSSLModes[settings.TLS]; settings.TLS != "" && !ok {
+		return fmt.Errorf("invalid tls %q, the tls profiles are only supported by %s", settings.TLS, DbDriverMySQL)
+	}
+	for _, option := range unsupported {
+		if option.set {
+			return fmt.Errorf("%s is not supported by the %s driver", option.name, settings.Driver)
+		}
+	}
+	return nil
+}
+
+// DbDriver returns the driver of a registered connection, DbDriverMySQL for the mocks, "" if it was not registered
+func DbDriver(name string) string {
+	settings, ok := allDbSettings[name]
+	if !ok {
+		return ""
+	}
+	if settings.Driver == "" {
+		return DbDriverMySQL
+	}
+	return settings.Driver
+}
+
+// Rebind replaces the ? placeholders of a query with the ones of a driver, $1, $2... for postgres,
+// the ? of the strings and quoted identifiers are left as is. The query is returned as is for the other drivers
+// ex : query, args, err := wconnectors.Named("SELECT * FROM users WHERE id = :id", user)
+//
+//	rows, err := db.QueryContext(ctx, wconnectors.Rebind(wconnectors.DbDriver("main"), query), args...)
+func Rebind(driverName string, query string) string {
+	if driverName != DbDriverPostgres && driverName != DbDriverPgx {
+		return query
+	}
+	var builder strings.Builder
+	placeholder := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			placeholder++
+			builder.WriteString("$" + strconv.Itoa(placeholder))
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+// postgresDSN returns the connection url of the settings, it is understood by lib/pq and pgx
+func (settings *DbSettings) postgresDSN() string {
+	query := url.Values{}
+	for key, value := range settings.Params {
+		query.Set(key, value)
+	}
+	port := settings.Port
+	if port == "" {
+		port = defaultDbPorts[settings.Driver]
+	}
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.User(settings.Username),
+		Host:   net.JoinHostPort(settings.Host, port),
+		Path:   "/" + settings.Database,
+	}
+	if settings.Password != "" {
+		dsn.User = url.UserPassword(settings.Username, settings.Password)
+	}
+	if settings.Socket != "" {
+		// the directory of the socket, the port is part of its name
+		dsn.Host = ""
+		query.Set("host", settings.Socket)
+		query.Set("port", port)
+	}
+	if settings.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(int(math.Ceil(settings.ConnectTimeout.Seconds()))))
+	}
+	if settings.Charset != "" {
+		query.Set("client_encoding", settings.Charset)
+	}
+	if settings.Loc != "" {
+		query.Set("timezone", settings.Loc)
+	}
+	if settings.TLS != "" {
+		query.Set("sslmode", postgresSSLModes[settings.TLS])
+	}
+	dsn.RawQuery = query.Encode()
+	return dsn.String()
+}
+
+// sqliteDSN returns the path of the file along with the params, ex: _pragma=busy_timeout(5000)
+func (settings *DbSettings) sqliteDSN() string {
+	if len(settings.Params) == 0 {
+		return settings.Database
+	}
+	query := url.Values{}
+	for key, value := range settings.Params {
+		query.Set(key, value)
+	}
+	separator := "?"
+	if strings.Contains(settings.Database, "?") {
+		separator = "&"
+	}
+	return settings.Database + separator + query.Encode()
+}
+
+// dbDSNConnector opens the connections of a driver that has no connector of its own
+type dbDSNConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+// Connect opens a connection
+func (connector *dbDSNConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return connector.driver.Open(connector.dsn)
+}
+
+// Driver returns the driver
+func (connector *dbDSNConnector) Driver() driver.Driver {
+	return connector.driver
+}
+
+// driverConnector returns the connector of a registered driver for a dsn
+func driverConnector(driverName string, dsn string) (driver.Connector, error) {
+	// database/sql does not give the drivers out by name
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	registeredDriver := db.Driver()
+	db.Close()
+	if driverContext, ok := registeredDriver.(driver.DriverContext); ok {
+		return driverContext.OpenConnector(dsn)
+	}
+	return &dbDSNConnector{driver: registeredDriver, dsn: dsn}, nil
+}
@@ -0,0 +1,76 @@
+package wconnectors
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPostgresDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings DbSettings
+		want     string
+	}{
+		{"host", DbSettings{Driver: DbDriverPostgres, Username: "app", Password: "secret", Host: "pg", Database: "main"}, "postgres://app:secret@pg:5432/main"},
+		{"port", DbSettings{Driver: DbDriverPgx, Username: "app", Host: "pg", Port: "6432", Database: "main"}, "postgres://app@pg:6432/main"},
+		{"escaped password", DbSettings{Driver: DbDriverPostgres, Username: "app", Password: "p@ss/word", Host: "pg", Database: "main"}, "postgres://app:p%40ss%2Fword@pg:5432/main"},
+		{"socket", DbSettings{Driver: DbDriverPostgres, Username: "app", Socket: "/var/run/postgresql", Database: "main"}, "postgres://app@/main?host=%2Fvar%2Frun%2Fpostgresql&port=5432"},
+		{
+			"options",
+			DbSettings{Driver: DbDriverPostgres, Username: "app", Host: "pg", Database: "main", ConnectTimeout: 1500 * time.Millisecond, Charset: "UTF8", Loc: "UTC", TLS: "skip-verify", Params: map[string]string{"application_name": "ads"}},
+			"postgres://app@pg:5432/main?application_name=ads&client_encoding=UTF8&connect_timeout=2&sslmode=require&timezone=UTC",
+		},
+	}
+	for _, test := range tests {
+		if got := test.settings.postgresDSN(); got != test.want {
+			t.Errorf("%s: postgresDSN() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings DbSettings
+		want     string
+	}{
+		{"file", DbSettings{Database: "/data/app.db"}, "/data/app.db"},
+		{"memory", DbSettings{Database: ":memory:"}, ":memory:"},
+		{"params", DbSettings{Database: "/data/app.db", Params: map[string]string{"_pragma": "busy_timeout(5000)"}}, "/data/app.db?_pragma=busy_timeout%285000%29"},
+		{"file uri", DbSettings{Database: "file:app.db?mode=ro", Params: map[string]string{"_txlock": "immediate"}}, "file:app.db?mode=ro&_txlock=immediate"},
+	}
+	for _, test := range tests {
+		if got := test.settings.sqliteDSN(); got != test.want {
+			t.Errorf("%s: sqliteDSN() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		driver string
+		query  string
+		want   string
+	}{
+		{DbDriverMySQL, "SELECT * FROM user WHERE id = ? AND status = ?", "SELECT * FROM user WHERE id = ? AND status = ?"},
+		{DbDriverPostgres, "SELECT * FROM users WHERE id = ? AND status = ?", "SELECT * FROM users WHERE id = $1 AND status = $2"},
+		{DbDriverPgx, "SELECT * FROM users WHERE id IN (?, ?, ?)", "SELECT * FROM users WHERE id IN ($1, $2, $3)"},
+		{DbDriverPostgres, "SELECT 'what?', \"col?\" FROM users WHERE note = 'it''s ?' AND id = ?", "SELECT 'what?', \"col?\" FROM users WHERE note = 'it''s ?' AND id = $1"},
+		{DbDriverSQLite, "SELECT * FROM user WHERE id = ?", "SELECT * FROM user WHERE id = ?"},
+	}
+	for _, test := range tests {
+		if got := Rebind(test.driver, test.query); got != test.want {
+			t.Errorf("Rebind(%s, %q) = %q, want %q", test.driver, test.query, got, test.want)
+		}
+	}
+}
+
+func TestDbDriver(t *testing.T) {
+	RegisterMockDb("driver_mock")
+	if driver := DbDriver("driver_mock"); driver != DbDriverMySQL {
+		t.Errorf("DbDriver(mock) = %q, want %q", driver, DbDriverMySQL)
+	}
+	if driver := DbDriver("driver_unknown"); driver != "" {
+		t.Errorf("DbDriver(unknown) = %q, want \"\"", driver)
+	}
+}
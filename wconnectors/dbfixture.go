@@ -0,0 +1,446 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// DbFixtureRecord records the exchanges of a connection with the server into its fixture file
+const DbFixtureRecord = "record"
+
+// DbFixtureReplay answers the queries of a connection from its fixture file, without a server
+const DbFixtureReplay = "replay"
+
+// dbFixture is the content of a fixture file
+type dbFixture struct {
+	Exchanges []dbExchange `json:"exchanges"`
+}
+
+// dbExchange is a query along with what the server answered
+type dbExchange struct {
+	Kind         string             `json:"kind"` // exec or query
+	Query        string             `json:"query"`
+	Args         []dbFixtureValue   `json:"args,omitempty"`
+	Columns      []string           `json:"columns,omitempty"`
+	Rows         [][]dbFixtureValue `json:"rows,omitempty"`
+	LastInsertID int64              `json:"last_insert_id,omitempty"`
+	RowsAffected int64              `json:"rows_affected,omitempty"`
+	Error        string             `json:"error,omitempty"`
+	ErrorNumber  uint16             `json:"error_number,omitempty"` // the number of a mysql error, so that it can be told apart in replay
+}
+
+// dbFixtureValue is a driver value along with its type, json alone would turn the integers into floats
+type dbFixtureValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+// key identifies the exchanges answering the same query with the same args
+func (exchange *dbExchange) key() string {
+	args, _ := json.Marshal(exchange.Args)
+	return exchange.Kind + "\x00" + strings.Join(strings.Fields(exchange.Query), " ") + "\x00" + string(args)
+}
+
+// encodeDbValue encodes a driver value
+func encodeDbValue(value driver.Value) dbFixtureValue {
+	switch typedValue := value.(type) {
+	case nil:
+		return dbFixtureValue{Type: "null"}
+	case int64:
+		return dbFixtureValue{Type: "int", Value: strconv.FormatInt(typedValue, 10)}
+	case uint64:
+		return dbFixtureValue{Type: "uint", Value: strconv.FormatUint(typedValue, 10)}
+	case float64:
+		return dbFixtureValue{Type: "float", Value: strconv.FormatFloat(typedValue, 'g', -1, 64)}
+	case bool:
+		return dbFixtureValue{Type: "bool", Value: strconv.FormatBool(typedValue)}
+	case string:
+		return dbFixtureValue{Type: "string", Value: typedValue}
+	case []byte:
+		return dbFixtureValue{Type: "bytes", Value: base64.StdEncoding.EncodeToString(typedValue)}
+	case time.Time:
+		return dbFixtureValue{Type: "time", Value: typedValue.Format(time.RFC3339Nano)}
+	}
+	return dbFixtureValue{Type: "string", Value: fmt.Sprint(value)}
+}
+
+// decode decodes a driver value
+func (value dbFixtureValue) decode() (driver.Value, error) {
+	switch value.Type {
+	case "null":
+		return nil, nil
+	case "int":
+		return strconv.ParseInt(value.Value, 10, 64)
+	case "uint":
+		return strconv.ParseUint(value.Value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(value.Value, 64)
+	case "bool":
+		return strconv.ParseBool(value.Value)
+	case "string":
+		return value.Value, nil
+	case "bytes":
+		return base64.StdEncoding.DecodeString(value.Value)
+	case "time":
+		return time.Parse(time.RFC3339Nano, value.Value)
+	}
+	return nil, fmt.Errorf("unknown fixture value type %q", value.Type)
+}
+
+// encodeDbArgs encodes the args of a query, nil without args as they are read back from the file
+func encodeDbArgs(args []driver.NamedValue) []dbFixtureValue {
+	if len(args) == 0 {
+		return nil
+	}
+	values := make([]dbFixtureValue, len(args))
+	for i, arg := range args {
+		values[i] = encodeDbValue(arg.Value)
+	}
+	return values
+}
+
+// copyValues copies a row, the driver reuses the memory of the []byte
+func copyValues(values []driver.Value) []driver.Value {
+	copied := make([]driver.Value, len(values))
+	for i, value := range values {
+		if bytes, ok := value.([]byte); ok {
+			value = append([]byte(nil), bytes...)
+		}
+		copied[i] = value
+	}
+	return copied
+}
+
+// dbRecorder keeps the exchanges of a connection, they are written into its fixture file when the db is closed
+// or by Shutdown
+type dbRecorder struct {
+	path    string
+	mutex   sync.Mutex
+	fixture dbFixture
+	written int // exchanges already in the file
+}
+
+// newDbRecorder starts a new recording, the previous content of the file is replaced
+func newDbRecorder(path string) *dbRecorder {
+	return &dbRecorder{path: path}
+}
+
+// recordResult records an exec
+func (recorder *dbRecorder) recordResult(query string, args []driver.NamedValue, result driver.Result, err error) {
+	exchange := dbExchange{Kind: "exec", Query: query, Args: encodeDbArgs(args)}
+	if err == nil && result != nil {
+		exchange.LastInsertID, _ = result.LastInsertId()
+		exchange.RowsAffected, _ = result.RowsAffected()
+	}
+	recorder.record(exchange, err)
+}
+
+// recordRows records a query and the rows that were read
+func (recorder *dbRecorder) recordRows(query string, args []driver.NamedValue, columns []string, rows [][]driver.Value, err error) {
+	exchange := dbExchange{Kind: "query", Query: query, Args: encodeDbArgs(args), Columns: columns}
+	for _, row := range rows {
+		values := make([]dbFixtureValue, len(row))
+		for i, value := range row {
+			values[i] = encodeDbValue(value)
+		}
+		exchange.Rows = append(exchange.Rows, values)
+	}
+	recorder.record(exchange, err)
+}
+
+// record adds an exchange
+func (recorder *dbRecorder) record(exchange dbExchange, err error) {
+	if errors.Is(err, driver.ErrBadConn) {
+		// database/sql runs the query again on another connection
+		return
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) {
+			exchange.Error = mysqlErr.Message
+			exchange.ErrorNumber = mysqlErr.Number
+		}
+	}
+
+	recorder.mutex.Lock()
+	recorder.fixture.Exchanges = append(recorder.fixture.Exchanges, exchange)
+	recorder.mutex.Unlock()
+}
+
+// flush writes the exchanges into the file unless it is up to date, the failures are also logged
+// since database/sql may be the one closing the db
+func (recorder *dbRecorder) flush() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.written == len(recorder.fixture.Exchanges) && recorder.written > 0 {
+		return nil
+	}
+	content, err := json.MarshalIndent(recorder.fixture, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(recorder.path, content, 0644)
+	}
+	if err != nil {
+		err = fmt.Errorf("wconnectors: db fixture %s: %w", recorder.path, err)
+		wlog.LogError(wlog.LevelError, err)
+		return err
+	}
+	recorder.written = len(recorder.fixture.Exchanges)
+	return nil
+}
+
+// dbReplay answers the queries from the exchanges of a fixture file, the exchanges of a query with the same args
+// are replayed in the order they were recorded, the last one is replayed again once they were all replayed
+type dbReplay struct {
+	name      string
+	path      string
+	mutex     sync.Mutex
+	exchanges map[string][]*dbExchange
+	replayed  map[string]int
+	queries   map[string][]string // the args recorded for a query, for the errors
+}
+
+// openDbReplay opens a connection answering from a fixture file
+func openDbReplay(name string, path string) (*sql.DB, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture dbFixture
+	if err := json.Unmarshal(content, &fixture); err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+
+	replay := &dbReplay{
+		name:      name,
+		path:      path,
+		exchanges: make(map[string][]*dbExchange),
+		replayed:  make(map[string]int),
+		queries:   make(map[string][]string),
+	}
+	for i := range fixture.Exchanges {
+		exchange := &fixture.Exchanges[i]
+		key := exchange.key()
+		replay.exchanges[key] = append(replay.exchanges[key], exchange)
+		query := exchange.Kind + " " + strings.Join(strings.Fields(exchange.Query), " ")
+		args, _ := json.Marshal(exchange.Args)
+		replay.queries[query] = append(replay.queries[query], string(args))
+	}
+	return sql.OpenDB(replay), nil
+}
+
+// Connect opens a connection to the fixture
+func (replay *dbReplay) Connect(ctx context.Context) (driver.Conn, error) {
+	return &dbReplayConn{replay: replay}, nil
+}
+
+// Driver returns the driver of the fixture
+func (replay *dbReplay) Driver() driver.Driver {
+	return dbReplayDriver{replay}
+}
+
+// next returns the exchange answering a query
+func (replay *dbReplay) next(kind string, query string, args []driver.NamedValue) (*dbExchange, error) {
+	wanted := dbExchange{Kind: kind, Query: query, Args: encodeDbArgs(args)}
+	key := wanted.key()
+
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+	exchanges, ok := replay.exchanges[key]
+	if !ok {
+		normalized := strings.Join(strings.Fields(query), " ")
+		argsJSON, _ := json.Marshal(wanted.Args)
+		err := fmt.Sprintf("wconnectors: db %s: %s was not recorded in %s: %s with args %s", replay.name, kind, replay.path, normalized, argsJSON)
+		if recorded := replay.queries[kind+" "+normalized]; len(recorded) > 0 {
+			if len(recorded) > 3 {
+				recorded = recorded[:3]
+			}
+			err += ", it was recorded with the args " + strings.Join(recorded, ", ")
+		}
+		return nil, errors.New(err)
+	}
+	index := replay.replayed[key]
+	if index >= len(exchanges) {
+		index = len(exchanges) - 1
+	}
+	replay.replayed[key] = index + 1
+	return exchanges[index], nil
+}
+
+// err returns the recorded error of an exchange
+func (exchange *dbExchange) err() error {
+	if exchange.ErrorNumber != 0 {
+		return &mysql.MySQLError{Number: exchange.ErrorNumber, Message: exchange.Error}
+	}
+	if exchange.Error != "" {
+		return errors.New(exchange.Error)
+	}
+	return nil
+}
+
+// dbReplayDriver is the driver of a fixture, the connections are opened through the fixture
+type dbReplayDriver struct {
+	replay *dbReplay
+}
+
+// Open opens a connection to the fixture
+func (replayDriver dbReplayDriver) Open(name string) (driver.Conn, error) {
+	return &dbReplayConn{replay: replayDriver.replay}, nil
+}
+
+// dbReplayConn is a connection to a fixture
+type dbReplayConn struct {
+	replay *dbReplay
+}
+
+// Prepare prepares a statement, it is answered from the fixture when it is run
+func (conn *dbReplayConn) Prepare(query string) (driver.Stmt, error) {
+	return &dbReplayStmt{conn: conn, query: query}, nil
+}
+
+// Close closes the connection
+func (conn *dbReplayConn) Close() error {
+	return nil
+}
+
+// Begin starts a transaction, the transactions are not recorded
+func (conn *dbReplayConn) Begin() (driver.Tx, error) {
+	return dbReplayTx{}, nil
+}
+
+// Ping always succeeds
+func (conn *dbReplayConn) Ping(ctx context.Context) error {
+	return nil
+}
+
+// ExecContext answers an exec from the fixture
+func (conn *dbReplayConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	exchange, err := conn.replay.next("exec", query, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := exchange.err(); err != nil {
+		return nil, err
+	}
+	return dbReplayResult{exchange}, nil
+}
+
+// QueryContext answers a query from the fixture
+func (conn *dbReplayConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	exchange, err := conn.replay.next("query", query, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := exchange.err(); err != nil && len(exchange.Rows) == 0 && len(exchange.Columns) == 0 {
+		return nil, err
+	}
+	return &dbReplayRows{exchange: exchange}, nil
+}
+
+// dbReplayStmt is a statement of a fixture
+type dbReplayStmt struct {
+	conn  *dbReplayConn
+	query string
+}
+
+// Close closes the statement
+func (stmt *dbReplayStmt) Close() error {
+	return nil
+}
+
+// NumInput lets database/sql pass any number of args
+func (stmt *dbReplayStmt) NumInput() int {
+	return -1
+}
+
+// Exec answers the statement from the fixture
+func (stmt *dbReplayStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return stmt.conn.ExecContext(context.Background(), stmt.query, valuesToNamedValues(args))
+}
+
+// Query answers the statement from the fixture
+func (stmt *dbReplayStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return stmt.conn.QueryContext(context.Background(), stmt.query, valuesToNamedValues(args))
+}
+
+// dbReplayTx is a transaction of a fixture, there is nothing to commit
+type dbReplayTx struct{}
+
+// Commit does nothing
+func (dbReplayTx) Commit() error {
+	return nil
+}
+
+// Rollback does nothing
+func (dbReplayTx) Rollback() error {
+	return nil
+}
+
+// dbReplayResult is the recorded result of an exec
+type dbReplayResult struct {
+	exchange *dbExchange
+}
+
+// LastInsertId returns the recorded id
+func (result dbReplayResult) LastInsertId() (int64, error) {
+	return result.exchange.LastInsertID, nil
+}
+
+// RowsAffected returns the recorded count
+func (result dbReplayResult) RowsAffected() (int64, error) {
+	return result.exchange.RowsAffected, nil
+}
+
+// dbReplayRows are the recorded rows of a query, followed by its recorded error if it failed while they were read
+type dbReplayRows struct {
+	exchange *dbExchange
+	index    int
+}
+
+// Columns returns the recorded columns
+func (rows *dbReplayRows) Columns() []string {
+	return rows.exchange.Columns
+}
+
+// Close closes the rows
+func (rows *dbReplayRows) Close() error {
+	return nil
+}
+
+// Next returns the next recorded row
+func (rows *dbReplayRows) Next(dest []driver.Value) error {
+	if rows.index >= len(rows.exchange.Rows) {
+		if err := rows.exchange.err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	row := rows.exchange.Rows[rows.index]
+	rows.index++
+	for i := range dest {
+		if i >= len(row) {
+			dest[i] = nil
+			continue
+		}
+		value, err := row[i].decode()
+		if err != nil {
+			return err
+		}
+		dest[i] = value
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestDbFixtureValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value driver.Value
+	}{
+		{"null", nil},
+		{"int", int64(-42)},
+		{"uint", uint64(18446744073709551615)},
+		{"float", 0.1},
+		{"bool", true},
+		{"string", "bob"},
+		{"bytes", []byte{0, 1, 255}},
+		{"time", time.Date(2020, 2, 29, 13, 14, 15, 123456789, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := encodeDbValue(test.value)
+			if encoded.Type != test.name {
+				t.Errorf("type = %q, want %q", encoded.Type, test.name)
+			}
+			content, err := json.Marshal(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded dbFixtureValue
+			if err := json.Unmarshal(content, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			value, err := decoded.decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, test.value) {
+				t.Errorf("decoded %#v, want %#v", value, test.value)
+			}
+		})
+	}
+
+	if _, err := (dbFixtureValue{Type: "decimal", Value: "1.5"}).decode(); err == nil {
+		t.Error("decode() of an unknown type = nil, want an error")
+	}
+}
+
+// tempDir returns a temporary directory and the function removing it
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "wconnectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// recordingDb returns a db recording into path the exchanges of a mocked server
+func recordingDb(t *testing.T, dsn string, path string) (*sql.DB, sqlmock.Sqlmock) {
+	// the dsn of a mock cannot be registered twice, ex: with go test -count
+	dsn += strconv.FormatInt(time.Now().UnixNano(), 36)
+	_, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector, err := driverConnector("sqlmock", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	observer := &dbObserver{name: dsn, recorder: newDbRecorder(path)}
+	return sql.OpenDB(&dbConnector{connector: connector, observer: observer}), mock
+}
+
+func TestDbFixtureRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	path := filepath.Join(dir, "fixture.json")
+	db, mock := recordingDb(t, "fixture_record", path)
+
+	const selectName = "SELECT name FROM user WHERE id = ?"
+	const selectIDs = "SELECT id FROM user ORDER BY id"
+	const insertUser = "INSERT INTO user (name) VALUES (?)"
+	mock.ExpectQuery(selectName).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("bob"))
+	mock.ExpectQuery(selectName).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("robert"))
+	mock.ExpectQuery(selectIDs).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(1)).AddRow(int64(2)).AddRow(int64(3)))
+	mock.ExpectExec(insertUser).WithArgs("alice").WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec(insertUser).WithArgs("alice").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+
+	var name string
+	if err := Get(ctx, db, &name, selectName, 1); err != nil || name != "bob" {
+		t.Fatalf("Get() = %q, %v", name, err)
+	}
+	if err := Get(ctx, db, &name, selectName, 1); err != nil || name != "robert" {
+		t.Fatalf("Get() = %q, %v", name, err)
+	}
+	// only the first row is read, the others are recorded anyway
+	var id int64
+	if err := Get(ctx, db, &id, selectIDs); err != nil || id != 1 {
+		t.Fatalf("Get() = %d, %v", id, err)
+	}
+	if _, err := db.ExecContext(ctx, insertUser, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, insertUser, "alice"); err == nil {
+		t.Fatal("ExecContext() = nil, want the duplicate entry")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectClose()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayDb, err := openDbReplay("fixture_replay", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayDb.Close()
+
+	// the same query is replayed in the order it was recorded, the last answer is reused
+	for _, want := range []string{"bob", "robert", "robert"} {
+		if err := Get(ctx, replayDb, &name, selectName, 1); err != nil || name != want {
+			t.Errorf("replayed Get() = %q, %v, want %q", name, err, want)
+		}
+	}
+	var ids []int64
+	if err := Select(ctx, replayDb, &ids, selectIDs); err != nil || !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Errorf("replayed Select() = %v, %v, want [1 2 3]", ids, err)
+	}
+	result, err := replayDb.ExecContext(ctx, insertUser, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID, _ := result.LastInsertId(); lastID != 5 {
+		t.Errorf("LastInsertId() = %d, want 5", lastID)
+	}
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		t.Errorf("RowsAffected() = %d, want 1", affected)
+	}
+	_, err = replayDb.ExecContext(ctx, insertUser, "alice")
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 || mysqlErr.Message != "Duplicate entry" {
+		t.Errorf("replayed ExecContext() = %#v, want the mysql error 1062", err)
+	}
+
+	err = Get(ctx, replayDb, &name, selectName, 2)
+	if err == nil || !strings.Contains(err.Error(), "was not recorded") || !strings.Contains(err.Error(), `it was recorded with the args [{"type":"int","value":"1"}]`) {
+		t.Errorf("Get() of another id = %v, want the recorded args", err)
+	}
+}
+
+func TestDbRecorderFlush(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	path := filepath.Join(dir, "fixture.json")
+	recorder := newDbRecorder(path)
+	recorder.recordResult("DELETE FROM user", nil, driver.RowsAffected(3), nil)
+
+	if err := recorder.flush(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture dbFixture
+	if err := json.Unmarshal(content, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	if len(fixture.Exchanges) != 1 || fixture.Exchanges[0].RowsAffected != 3 {
+		t.Errorf("exchanges = %+v, want the delete", fixture.Exchanges)
+	}
+
+	// up to date, the file is not written again
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the file was written again: %v", err)
+	}
+
+	recorder.recordResult("DELETE FROM ad", nil, driver.RowsAffected(1), nil)
+	if err := recorder.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the new exchange was not written: %v", err)
+	}
+
+	missing := newDbRecorder(filepath.Join(path, "missing", "fixture.json"))
+	if err := missing.flush(); err == nil {
+		t.Error("flush() into a missing directory = nil, want an error")
+	}
+}
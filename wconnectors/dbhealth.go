@@ -0,0 +1,179 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// defaultDbPingTimeout bounds the pings when the connection has no ConnectTimeout
+const defaultDbPingTimeout = 5 * time.Second
+
+var dbFailFast bool
+
+// SetDbFailFast makes RegisterDb open and ping the connection right away and return the error if it fails,
+// and makes DbE return the ping errors instead of logging them, so that a service does not start with a dead database
+func SetDbFailFast(failFast bool) {
+	dbFailFast = failFast
+}
+
+// DbStatus is the state of a connection as reported by PingAll
+type DbStatus struct {
+	Name    string
+	Replica string // host:port of the replica, empty for the primary
+	Err     error  // nil if the ping succeeded
+	Latency time.Duration
+	Stats   sql.DBStats
+}
+
+// pingDb pings a connection, bounded by its connect timeout
+func pingDb(ctx context.Context, db *sql.DB, settings DbSettings) error {
+	timeout := settings.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultDbPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// PingAll pings every registered connection, the replicas included, and returns their status sorted by name,
+// the error lists the connections that failed
+// ex : http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { if _, err := wconnectors.PingAll(r.Context()); err != nil { ... } })
+func PingAll(ctx context.Context) ([]DbStatus, error) {
+	names := make([]string, 0, len(allDbSettings))
+	for name := range allDbSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := make([]DbStatus, 0, len(names))
+	var failures []string
+	for _, name := range names {
+		status := DbStatus{Name: name}
+		db, err := DbE(name)
+		if err == nil {
+			start := time.Now()
+			err = pingDb(ctx, db, allDbSettings[name])
+			status.Latency = time.Since(start)
+			status.Stats = db.Stats()
+		}
+		status.Err = err
+		statuses = append(statuses, status)
+
+		dbOnceMutex.Lock()
+		replicaSet := dbReplicaSets[name]
+		dbOnceMutex.Unlock()
+		if replicaSet != nil {
+			for _, replica := range replicaSet.replicas {
+				replicaStatus := DbStatus{Name: name, Replica: replica.addr}
+				start := time.Now()
+				replicaStatus.Err = pingDb(ctx, replica.db, replicaSet.settings)
+				replicaStatus.Latency = time.Since(start)
+				replicaStatus.Stats = replica.db.Stats()
+				statuses = append(statuses, replicaStatus)
+			}
+		}
+	}
+
+	for _, status := range statuses {
+		if status.Err != nil {
+			failures = append(failures, status.String())
+		}
+	}
+	if len(failures) > 0 {
+		return statuses, fmt.Errorf("wconnectors: %s", strings.Join(failures, ", "))
+	}
+	return statuses, nil
+}
+
+// String describes the status, ex: "main: ok (2ms)" or "main replica db2:3306: dial tcp: connection refused"
+func (status DbStatus) String() string {
+	name := status.Name
+	if status.Replica != "" {
+		name += " replica " + status.Replica
+	}
+	if status.Err != nil {
+		return name + ": " + status.Err.Error()
+	}
+	return fmt.Sprintf("%s: ok (%s)", name, status.Latency.Round(time.Millisecond))
+}
+
+// fields returns the fields of the entries logged about the status
+func (status DbStatus) fields() wlog.Option {
+	fields := map[string]interface{}{"db": status.Name}
+	if status.Replica != "" {
+		fields["replica"] = status.Replica
+	}
+	return wlog.Fields(fields)
+}
+
+// defaultDbHealthMonitorInterval is the interval of the monitor when none is given
+const defaultDbHealthMonitorInterval = 30 * time.Second
+
+var dbHealthMonitorRunning int32
+
+// dbHealthMonitorStop stops the running monitor, for Shutdown
+var dbHealthMonitorStop func()
+var dbHealthMonitorStopMutex sync.Mutex
+
+// StartDbHealthMonitor pings the registered connections every interval and logs through wlog when one goes
+// down (as an error) or comes back (as a notice), it returns the function stopping the monitor.
+// An interval <= 0, ex: a missing config value, gives 30s
+// ex : stop := wconnectors.StartDbHealthMonitor(30 * time.Second); defer stop()
+func StartDbHealthMonitor(interval time.Duration) func() {
+	if interval <= 0 {
+		interval = defaultDbHealthMonitorInterval
+	}
+	if !atomic.CompareAndSwapInt32(&dbHealthMonitorRunning, 0, 1) {
+		wlog.Log(wlog.LevelWarning, "the db health monitor is already running")
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer atomic.StoreInt32(&dbHealthMonitorRunning, 0)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// the connections are considered up until a ping fails
+		down := make(map[string]bool)
+		for {
+			statuses, _ := PingAll(context.Background())
+			for _, status := range statuses {
+				key := status.Name + "/" + status.Replica
+				if status.Err != nil && !down[key] {
+					down[key] = true
+					wlog.Log(wlog.LevelError, "db down: "+status.String(), status.fields())
+				} else if status.Err == nil && down[key] {
+					delete(down, key)
+					wlog.Log(wlog.LevelNotice, "db back up: "+status.String(), status.fields())
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			close(done)
+		})
+	}
+	dbHealthMonitorStopMutex.Lock()
+	dbHealthMonitorStop = stop
+	dbHealthMonitorStopMutex.Unlock()
+	return stop
+}
@@ -0,0 +1,24 @@
+package wconnectors
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartDbHealthMonitorInterval(t *testing.T) {
+	// a ticker of 0 panics in the goroutine of the monitor, which would end the tests
+	for _, interval := range []time.Duration{0, -time.Second} {
+		stop := StartDbHealthMonitor(interval)
+		time.Sleep(10 * time.Millisecond)
+		stop()
+		for i := 0; i < 100 && !dbHealthMonitorStopped(); i++ {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// dbHealthMonitorStopped tells whether the goroutine of the monitor returned
+func dbHealthMonitorStopped() bool {
+	return atomic.LoadInt32(&dbHealthMonitorRunning) == 0
+}
@@ -0,0 +1,14 @@
+//go:build go1.15
+// +build go1.15
+
+package wconnectors
+
+import (
+	"database/sql"
+	"time"
+)
+
+// setConnMaxIdleTime closes the connections idle for longer than duration, 0 keeps them
+func setConnMaxIdleTime(db *sql.DB, duration time.Duration) {
+	db.SetConnMaxIdleTime(duration)
+}
@@ -0,0 +1,17 @@
+//go:build !go1.15
+// +build !go1.15
+
+package wconnectors
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// setConnMaxIdleTime is not supported before go 1.15, MaxIdleConns can be used instead
+func setConnMaxIdleTime(db *sql.DB, duration time.Duration) {
+	if duration > 0 {
+		log.Println("ConnMaxIdleTime requires go 1.15, it is ignored")
+	}
+}
@@ -0,0 +1,238 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/webediads/adsgolib/wcontext"
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// DbBalanceRoundRobin sends the reads to the healthy replicas in turn
+const DbBalanceRoundRobin = "round_robin"
+
+// DbBalanceLeastConns sends the reads to the healthy replica with the fewest connections in use
+const DbBalanceLeastConns = "least_conns"
+
+// default replica settings
+const (
+	defaultDbReplicaCheckInterval = 10 * time.Second
+	dbReplicaCheckTimeout         = 2 * time.Second
+)
+
+var dbReplicaSets = make(map[string]*dbReplicaSet)
+
+// dbReplicaSet holds the replicas of a connection
+type dbReplicaSet struct {
+	name      string
+	settings  DbSettings
+	replicas  []*dbReplica
+	next      uint32
+	stopCheck chan struct{}
+}
+
+// dbReplica is a replica along with the result of its last health check
+type dbReplica struct {
+	addr    string
+	db      *sql.DB
+	healthy int32
+	breaker *Breaker
+}
+
+// DbReader returns a connection for the reads: a healthy replica if the connection has replicas, the primary
+// otherwise or if ctx was returned by ReadFromPrimary. Db still returns the primary, for the writes and transactions
+// ex : rows, err := wconnectors.DbReader(r.Context(), "main").QueryContext(r.Context(), "SELECT ...")
+func DbReader(ctx context.Context, name string) *sql.DB {
+	primary := Db(name)
+	if ctx != nil {
+		if readFromPrimary, _ := ctx.Value(wcontext.ContextKeyReadFromPrimary).(bool); readFromPrimary {
+			return primary
+		}
+	}
+
+	dbOnceMutex.Lock()
+	replicaSet := dbReplicaSets[name]
+	dbOnceMutex.Unlock()
+	if replicaSet == nil {
+		return primary
+	}
+	if replica := replicaSet.pick(); replica != nil {
+		return replica
+	}
+	return primary
+}
+
+// ReadFromPrimary returns a context sending the reads of DbReader to the primary, for the reads that must see
+// a write that was just made
+func ReadFromPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, wcontext.ContextKeyReadFromPrimary, true)
+}
+
+// validateReplicas checks the replica settings and sets the default values
+func (settings *DbSettings) validateReplicas() error {
+	if settings.ReplicaBalancing == "" {
+		settings.ReplicaBalancing = DbBalanceRoundRobin
+	}
+	if settings.ReplicaBalancing != DbBalanceRoundRobin && settings.ReplicaBalancing != DbBalanceLeastConns {
+		return fmt.Errorf("invalid replica balancing %q, %s or %s expected", settings.ReplicaBalancing, DbBalanceRoundRobin, DbBalanceLeastConns)
+	}
+	if settings.ReplicaCheckInterval < 0 || settings.MaxReplicaLag < 0 {
+		return errors.New("replica check interval and max replica lag cannot be negative")
+	}
+	if settings.ReplicaCheckInterval == 0 {
+		settings.ReplicaCheckInterval = defaultDbReplicaCheckInterval
+	}
+	// the slice belongs to the caller
+	settings.Replicas = append([]string(nil), settings.Replicas...)
+	for i, replica := range settings.Replicas {
+		host, port, err := net.SplitHostPort(replica)
+		if err != nil {
+			// the port of the primary is used by default
+			host, port = replica, settings.Port
+		}
+		if host == "" {
+			return fmt.Errorf("invalid replica %q", replica)
+		}
+		if portNumber, err := strconv.Atoi(port); err != nil || portNumber <= 0 || portNumber > 65535 {
+			return fmt.Errorf("invalid port for replica %q", replica)
+		}
+		settings.Replicas[i] = net.JoinHostPort(host, port)
+	}
+	return nil
+}
+
+// openDbReplicas opens the replicas of a connection and starts checking their health,
+// they are used once they passed a first check
+func openDbReplicas(name string, settings DbSettings) *dbReplicaSet {
+	replicaSet := &dbReplicaSet{name: name, settings: settings, stopCheck: make(chan struct{})}
+	for _, addr := range settings.Replicas {
+		replicaSettings := settings
+		replicaSettings.Socket = ""
+		replicaSettings.Host, replicaSettings.Port, _ = net.SplitHostPort(addr)
+		breaker := replicaSettings.breaker("db " + name + " replica " + addr)
+		db, err := openDb(name, replicaSettings, breaker)
+		if err != nil {
+			wlog.LogError(wlog.LevelError, wlog.Wrap(err, "db "+name+": replica "+addr))
+			continue
+		}
+		replicaSet.replicas = append(replicaSet.replicas, &dbReplica{addr: addr, db: db, breaker: breaker})
+	}
+	go replicaSet.checkHealth()
+	return replicaSet
+}
+
+// pick returns a healthy replica, nil if there is none
+func (replicaSet *dbReplicaSet) pick() *sql.DB {
+	var healthy []*dbReplica
+	for _, replica := range replicaSet.replicas {
+		// the reads go to the other replicas or to the primary while the breaker of a replica is open
+		if atomic.LoadInt32(&replica.healthy) == 1 && replica.breaker.ready() {
+			healthy = append(healthy, replica)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	if replicaSet.settings.ReplicaBalancing == DbBalanceLeastConns {
+		picked := healthy[0]
+		pickedInUse := picked.db.Stats().InUse
+		for _, replica := range healthy[1:] {
+			if inUse := replica.db.Stats().InUse; inUse < pickedInUse {
+				picked, pickedInUse = replica, inUse
+			}
+		}
+		return picked.db
+	}
+	next := atomic.AddUint32(&replicaSet.next, 1)
+	return healthy[int(next)%len(healthy)].db
+}
+
+// checkHealth checks the replicas every ReplicaCheckInterval until the set is closed
+func (replicaSet *dbReplicaSet) checkHealth() {
+	ticker := time.NewTicker(replicaSet.settings.ReplicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, replica := range replicaSet.replicas {
+			replicaSet.check(replica)
+		}
+		select {
+		case <-ticker.C:
+		case <-replicaSet.stopCheck:
+			return
+		}
+	}
+}
+
+// check ejects a replica that cannot be reached or lags too far behind, and brings it back once it recovered
+func (replicaSet *dbReplicaSet) check(replica *dbReplica) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbReplicaCheckTimeout)
+	defer cancel()
+
+	err := replica.db.PingContext(ctx)
+	if err == nil && replicaSet.settings.MaxReplicaLag > 0 {
+		var lag time.Duration
+		lag, err = replicationLag(ctx, replica.db)
+		if err == nil && lag > replicaSet.settings.MaxReplicaLag {
+			err = fmt.Errorf("replication lag of %s", lag)
+		}
+	}
+
+	healthy := int32(0)
+	if err == nil {
+		healthy = 1
+	}
+	if previous := atomic.SwapInt32(&replica.healthy, healthy); previous != healthy {
+		if err != nil {
+			wlog.Log(wlog.LevelWarning, "db "+replicaSet.name+": replica "+replica.addr+" ejected: "+err.Error(), wlog.Field("db", replicaSet.name), wlog.Field("replica", replica.addr))
+		} else {
+			wlog.Log(wlog.LevelNotice, "db "+replicaSet.name+": replica "+replica.addr+" is healthy", wlog.Field("db", replicaSet.name), wlog.Field("replica", replica.addr))
+		}
+	}
+}
+
+// replicationLag returns the Seconds_Behind_Master of a replica, an error if the replication is stopped
+func replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, errors.New("not a replica")
+	}
+	values := make([]sql.RawBytes, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return 0, err
+	}
+	for i, column := range columns {
+		if !strings.EqualFold(column, "Seconds_Behind_Master") {
+			continue
+		}
+		if values[i] == nil {
+			return 0, errors.New("replication is stopped")
+		}
+		seconds, err := strconv.Atoi(string(values[i]))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("no Seconds_Behind_Master column")
+}
@@ -0,0 +1,444 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DbQuerier runs the queries of Select and Get, it is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type DbQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// DbExecer runs the queries of ExecNamed, it is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type DbExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Select runs a query and scans its rows into dest, a pointer to a slice of structs, of pointers to structs or of
+// single values. The columns go to the struct fields by their db tag, ex: `db:"created_at"`, or by their lowercased
+// name, the fields of the embedded structs included, `db:"-"` skips a field. A column without a field is an error.
+// The slices of args are expanded for their ?, see In
+// ex : var users []User; err := wconnectors.Select(ctx, wconnectors.Db("main"), &users, "SELECT * FROM user WHERE id IN (?)", ids)
+func Select(ctx context.Context, db DbQuerier, dest interface{}, query string, args ...interface{}) error {
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("wconnectors: Select expects a pointer to a slice, got %T", dest)
+	}
+	slice := value.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	query, args, err := In(query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	scanner, err := newRowScanner(rows, elemType)
+	if err != nil {
+		return err
+	}
+	// an empty result leaves an empty slice rather than nil, so that it is encoded as []
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, 0))
+	for rows.Next() {
+		elem := reflect.New(elemType)
+		if err := scanner.scan(rows, elem.Elem()); err != nil {
+			return err
+		}
+		if isPtr {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+	return rows.Err()
+}
+
+// Get runs a query and scans its first row into dest, a pointer to a struct or to a single value,
+// it returns sql.ErrNoRows if there is none, see Select
+// ex : var user User; err := wconnectors.Get(ctx, wconnectors.Db("main"), &user, "SELECT * FROM user WHERE id = ?", id)
+func Get(ctx context.Context, db DbQuerier, dest interface{}, query string, args ...interface{}) error {
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("wconnectors: Get expects a pointer, got %T", dest)
+	}
+
+	query, args, err := In(query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	scanner, err := newRowScanner(rows, value.Elem().Type())
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := scanner.scan(rows, value.Elem()); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// SelectNamed is Select with the :name parameters of the query bound from arg, see Named
+// ex : err := wconnectors.SelectNamed(ctx, db, &users, "SELECT * FROM user WHERE country = :country AND id IN (:ids)", map[string]interface{}{"country": "fr", "ids": ids})
+func SelectNamed(ctx context.Context, db DbQuerier, dest interface{}, query string, arg interface{}) error {
+	query, args, err := Named(query, arg)
+	if err != nil {
+		return err
+	}
+	return Select(ctx, db, dest, query, args...)
+}
+
+// GetNamed is Get with the :name parameters of the query bound from arg, see Named
+func GetNamed(ctx context.Context, db DbQuerier, dest interface{}, query string, arg interface{}) error {
+	query, args, err := Named(query, arg)
+	if err != nil {
+		return err
+	}
+	return Get(ctx, db, dest, query, args...)
+}
+
+// ExecNamed runs a query with the :name parameters of the query bound from arg, see Named
+// ex : _, err := wconnectors.ExecNamed(ctx, db, "UPDATE user SET email = :email WHERE id = :id", user)
+func ExecNamed(ctx context.Context, db DbExecer, query string, arg interface{}) (sql.Result, error) {
+	query, args, err := Named(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err = In(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, query, args...)
+}
+
+// Named replaces the :name parameters of a query with ? and returns their values, taken from a struct
+// (by db tag or lowercased field name, as Select) or from a map[string]interface{}.
+// The :name of the strings and quoted identifiers are left as is, so is :=, the ? are the placeholders of mysql, see Rebind
+// ex : query, args, err := wconnectors.Named("SELECT * FROM user WHERE id = :id", map[string]interface{}{"id": 42})
+func Named(query string, arg interface{}) (string, []interface{}, error) {
+	lookup, err := namedLookup(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var builder strings.Builder
+	var args []interface{}
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(query) {
+				builder.WriteByte(c)
+				i++
+				c = query[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			builder.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(query) && isNameStart(query[i+1]):
+			end := i + 1
+			for end < len(query) && isNamePart(query[end]) {
+				end++
+			}
+			name := query[i+1 : end]
+			value, ok := lookup(name)
+			if !ok {
+				return "", nil, fmt.Errorf("wconnectors: no value for the :%s parameter", name)
+			}
+			args = append(args, value)
+			builder.WriteByte('?')
+			i = end - 1
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String(), args, nil
+}
+
+// isNameStart tells whether c can start the name of a parameter
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isNamePart tells whether c can be part of the name of a parameter
+func isNamePart(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// namedLookup returns the function finding the named parameters in a struct or a map
+func namedLookup(arg interface{}) (func(name string) (interface{}, bool), error) {
+	if params, ok := arg.(map[string]interface{}); ok {
+		return func(name string) (interface{}, bool) {
+			value, ok := params[name]
+			return value, ok
+		}, nil
+	}
+
+	value := reflect.ValueOf(arg)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			break
+		}
+		return func(name string) (interface{}, bool) {
+			param := value.MapIndex(reflect.ValueOf(name).Convert(value.Type().Key()))
+			if !param.IsValid() {
+				return nil, false
+			}
+			return param.Interface(), true
+		}, nil
+	case reflect.Struct:
+		fields := structFields(value.Type())
+		return func(name string) (interface{}, bool) {
+			index, ok := fields[strings.ToLower(name)]
+			if !ok {
+				return nil, false
+			}
+			field, ok := fieldByIndex(value, index, false)
+			if !ok {
+				// a nil embedded pointer
+				return nil, true
+			}
+			return field.Interface(), true
+		}, nil
+	}
+	return nil, fmt.Errorf("wconnectors: the named parameters are taken from a struct or a map, got %T", arg)
+}
+
+// In expands the ? of the slices of args into as many ? as the slice has values, []byte and the driver.Valuer excepted,
+// see Rebind for the placeholders of postgres
+// ex : query, args, err := wconnectors.In("SELECT * FROM user WHERE id IN (?) AND status = ?", []int{1, 2, 3}, "active")
+// gives "SELECT * FROM user WHERE id IN (?, ?, ?) AND status = ?" and [1 2 3 active]
+func In(query string, args ...interface{}) (string, []interface{}, error) {
+	expand := false
+	for _, arg := range args {
+		if _, ok := asSlice(arg); ok {
+			expand = true
+			break
+		}
+	}
+	if !expand {
+		return query, args, nil
+	}
+
+	var builder strings.Builder
+	expanded := make([]interface{}, 0, len(args))
+	argIndex := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(query) {
+				builder.WriteByte(c)
+				i++
+				c = query[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			if argIndex >= len(args) {
+				return "", nil, fmt.Errorf("wconnectors: the query has more ? than the %d args", len(args))
+			}
+			arg := args[argIndex]
+			argIndex++
+			slice, ok := asSlice(arg)
+			if !ok {
+				expanded = append(expanded, arg)
+				break
+			}
+			if slice.Len() == 0 {
+				return "", nil, fmt.Errorf("wconnectors: arg %d is an empty slice, IN () is not valid sql", argIndex)
+			}
+			for j := 0; j < slice.Len(); j++ {
+				if j > 0 {
+					builder.WriteString(", ")
+				}
+				builder.WriteByte('?')
+				expanded = append(expanded, slice.Index(j).Interface())
+			}
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	if argIndex != len(args) {
+		return "", nil, fmt.Errorf("wconnectors: the query has %d ? for %d args", argIndex, len(args))
+	}
+	return builder.String(), expanded, nil
+}
+
+// asSlice returns the slice to expand of an argument
+func asSlice(arg interface{}) (reflect.Value, bool) {
+	if arg == nil {
+		return reflect.Value{}, false
+	}
+	if _, ok := arg.(driver.Valuer); ok {
+		return reflect.Value{}, false
+	}
+	value := reflect.ValueOf(arg)
+	if value.Kind() != reflect.Slice || value.Type().Elem().Kind() == reflect.Uint8 {
+		return reflect.Value{}, false
+	}
+	return value, true
+}
+
+// rowScanner scans the rows of a query into a type
+type rowScanner struct {
+	// index of the field of each column, nil when the row is scanned into a single value
+	indexes [][]int
+}
+
+// newRowScanner maps the columns of the rows to the fields of a type
+func newRowScanner(rows *sql.Rows, destType reflect.Type) (*rowScanner, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !isStructDest(destType) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("wconnectors: %d columns cannot be scanned into a %s", len(columns), destType)
+		}
+		return &rowScanner{}, nil
+	}
+
+	fields := structFields(destType)
+	indexes := make([][]int, len(columns))
+	for i, column := range columns {
+		index, ok := fields[strings.ToLower(column)]
+		if !ok {
+			return nil, fmt.Errorf("wconnectors: no field of %s for the column %s", destType, column)
+		}
+		indexes[i] = index
+	}
+	return &rowScanner{indexes: indexes}, nil
+}
+
+// scan scans the current row into dest
+func (scanner *rowScanner) scan(rows *sql.Rows, dest reflect.Value) error {
+	if scanner.indexes == nil {
+		return rows.Scan(dest.Addr().Interface())
+	}
+	pointers := make([]interface{}, len(scanner.indexes))
+	for i, index := range scanner.indexes {
+		field, _ := fieldByIndex(dest, index, true)
+		pointers[i] = field.Addr().Interface()
+	}
+	return rows.Scan(pointers...)
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+var timeType = reflect.TypeOf(time.Time{})
+
+// isStructDest tells whether the columns go to the fields of the type, the structs scanned as a whole excepted
+func isStructDest(destType reflect.Type) bool {
+	return destType.Kind() == reflect.Struct && destType != timeType && !reflect.PtrTo(destType).Implements(scannerType)
+}
+
+var structFieldsCache sync.Map
+
+// structFields returns the index of the fields of a struct by column name, the fields of the embedded structs included
+func structFields(structType reflect.Type) map[string][]int {
+	if fields, ok := structFieldsCache.Load(structType); ok {
+		return fields.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	addStructFields(fields, structType, nil)
+	structFieldsCache.Store(structType, fields)
+	return fields
+}
+
+// addStructFields adds the fields of a struct, the ones of the outer structs win over the ones of the embedded structs
+func addStructFields(fields map[string][]int, structType reflect.Type, parent []int) {
+	var embedded []reflect.StructField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && tag == "" && isStructDest(fieldType) {
+			// a nil unexported embedded pointer cannot be allocated
+			if field.PkgPath == "" || field.Type.Kind() != reflect.Ptr {
+				embedded = append(embedded, field)
+			}
+			continue
+		}
+		// the unexported fields cannot be set
+		if field.PkgPath != "" {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = field.Name
+		}
+		name = strings.ToLower(name)
+		if _, ok := fields[name]; !ok {
+			fields[name] = append(append([]int(nil), parent...), i)
+		}
+	}
+	for _, field := range embedded {
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		addStructFields(fields, fieldType, append(append([]int(nil), parent...), field.Index...))
+	}
+}
+
+// fieldByIndex returns a field of a struct, the nil embedded pointers on the way are allocated if alloc is set,
+// it returns false if one of them is nil otherwise
+func fieldByIndex(value reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, fieldIndex := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(fieldIndex)
+	}
+	return value, true
+}
@@ -0,0 +1,247 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+type namedUser struct {
+	ID     int `db:"user_id"`
+	Name   string
+	Status string `db:"-"`
+}
+
+func TestNamed(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		arg       interface{}
+		wantQuery string
+		wantArgs  []interface{}
+		wantErr   bool
+	}{
+		{"map", "SELECT * FROM user WHERE id = :id AND name = :name", map[string]interface{}{"id": 42, "name": "bob"},
+			"SELECT * FROM user WHERE id = ? AND name = ?", []interface{}{42, "bob"}, false},
+		{"repeated", "SELECT * FROM user WHERE id = :id OR parent_id = :id", map[string]interface{}{"id": 42},
+			"SELECT * FROM user WHERE id = ? OR parent_id = ?", []interface{}{42, 42}, false},
+		{"struct by tag and lowercased name", "UPDATE user SET name = :name WHERE id = :user_id", namedUser{ID: 42, Name: "bob"},
+			"UPDATE user SET name = ? WHERE id = ?", []interface{}{"bob", 42}, false},
+		{"struct pointer", "SELECT :Name", &namedUser{Name: "bob"}, "SELECT ?", []interface{}{"bob"}, false},
+		{"typed map", "SELECT :id", map[string]int{"id": 42}, "SELECT ?", []interface{}{42}, false},
+		{"strings and identifiers", "SELECT ':id', \"a:id\", `b:id`, 'it\\'s :id' FROM t WHERE id = :id", map[string]interface{}{"id": 1},
+			"SELECT ':id', \"a:id\", `b:id`, 'it\\'s :id' FROM t WHERE id = ?", []interface{}{1}, false},
+		{"casts and assignments", "SELECT :id::text, @n := 1", map[string]interface{}{"id": 1},
+			"SELECT ?::text, @n := 1", []interface{}{1}, false},
+		{"time is a value", "SELECT * FROM ad WHERE start < :now", map[string]interface{}{"now": time.Time{}},
+			"SELECT * FROM ad WHERE start < ?", []interface{}{time.Time{}}, false},
+		{"missing value", "SELECT :id, :other", map[string]interface{}{"id": 1}, "", nil, true},
+		{"ignored field", "SELECT :status", namedUser{Status: "active"}, "", nil, true},
+		{"not a struct or a map", "SELECT :id", 42, "", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, args, err := Named(test.query, test.arg)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Named() error = %v, want error %v", err, test.wantErr)
+			}
+			if query != test.wantQuery {
+				t.Errorf("Named() query = %q, want %q", query, test.wantQuery)
+			}
+			if !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("Named() args = %v, want %v", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestIn(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		args      []interface{}
+		wantQuery string
+		wantArgs  []interface{}
+		wantErr   bool
+	}{
+		{"no slice", "SELECT * FROM user WHERE id = ?", []interface{}{1},
+			"SELECT * FROM user WHERE id = ?", []interface{}{1}, false},
+		{"slice", "SELECT * FROM user WHERE id IN (?) AND status = ?", []interface{}{[]int{1, 2, 3}, "active"},
+			"SELECT * FROM user WHERE id IN (?, ?, ?) AND status = ?", []interface{}{1, 2, 3, "active"}, false},
+		{"two slices", "SELECT * FROM ad WHERE id IN (?) OR slot IN (?)", []interface{}{[]int64{1}, []string{"top", "side"}},
+			"SELECT * FROM ad WHERE id IN (?) OR slot IN (?, ?)", []interface{}{int64(1), "top", "side"}, false},
+		{"bytes are a value", "SELECT * FROM ad WHERE hash = ? AND id IN (?)", []interface{}{[]byte("ab"), []int{1, 2}},
+			"SELECT * FROM ad WHERE hash = ? AND id IN (?, ?)", []interface{}{[]byte("ab"), 1, 2}, false},
+		{"valuers are a value", "SELECT * FROM ad WHERE name = ? AND id IN (?)", []interface{}{sql.NullString{}, []int{1}},
+			"SELECT * FROM ad WHERE name = ? AND id IN (?)", []interface{}{sql.NullString{}, 1}, false},
+		{"strings are skipped", "SELECT '?' FROM ad WHERE id IN (?)", []interface{}{[]int{1, 2}},
+			"SELECT '?' FROM ad WHERE id IN (?, ?)", []interface{}{1, 2}, false},
+		{"empty slice", "SELECT * FROM ad WHERE id IN (?)", []interface{}{[]int{}}, "", nil, true},
+		{"too many ?", "SELECT * FROM ad WHERE id IN (?) AND slot = ?", []interface{}{[]int{1}}, "", nil, true},
+		{"too many args", "SELECT * FROM ad WHERE id IN (?)", []interface{}{[]int{1}, 2}, "", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, args, err := In(test.query, test.args...)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("In() error = %v, want error %v", err, test.wantErr)
+			}
+			if query != test.wantQuery {
+				t.Errorf("In() query = %q, want %q", query, test.wantQuery)
+			}
+			if !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("In() args = %v, want %v", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+type ScanOwner struct {
+	OwnerName string `db:"owner_name"`
+}
+
+type scanAudit struct {
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedBy sql.NullString `db:"updated_by"`
+}
+
+type scanAd struct {
+	ID     int64 `db:"id"`
+	Title  string
+	Price  sql.NullFloat64
+	Secret string `db:"-"`
+	scanAudit
+	*ScanOwner
+}
+
+func TestSelect(t *testing.T) {
+	ctx := context.Background()
+	RegisterMockDb("scan_select")
+	db := Db("scan_select")
+	mock := DbMock("scan_select")
+	created := time.Date(2020, 2, 29, 13, 14, 15, 0, time.UTC)
+	adColumns := []string{"id", "title", "price", "created_at", "updated_by", "owner_name"}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM ad WHERE id IN (?, ?)")).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(adColumns).
+		AddRow(1, "bike", 99.5, created, "bob", "alice").
+		AddRow(2, "car", nil, created, nil, "carol"))
+	var ads []scanAd
+	if err := Select(ctx, db, &ads, "SELECT * FROM ad WHERE id IN (?)", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := []scanAd{
+		{ID: 1, Title: "bike", Price: sql.NullFloat64{Float64: 99.5, Valid: true},
+			scanAudit: scanAudit{CreatedAt: created, UpdatedBy: sql.NullString{String: "bob", Valid: true}}, ScanOwner: &ScanOwner{OwnerName: "alice"}},
+		// the nil embedded pointer is allocated for its column
+		{ID: 2, Title: "car", scanAudit: scanAudit{CreatedAt: created}, ScanOwner: &ScanOwner{OwnerName: "carol"}},
+	}
+	if !reflect.DeepEqual(ads, want) {
+		t.Errorf("Select() = %+v, want %+v", ads, want)
+	}
+
+	mock.ExpectQuery("SELECT id, title FROM ad").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "bike"))
+	var adPointers []*scanAd
+	if err := Select(ctx, db, &adPointers, "SELECT id, title FROM ad"); err != nil {
+		t.Fatal(err)
+	}
+	if len(adPointers) != 1 || adPointers[0].Title != "bike" || adPointers[0].ScanOwner != nil {
+		t.Errorf("Select() of pointers = %+v, want the bike without an owner", adPointers)
+	}
+
+	mock.ExpectQuery("SELECT id FROM ad").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4))
+	var ids []int64
+	if err := Select(ctx, db, &ids, "SELECT id FROM ad"); err != nil || !reflect.DeepEqual(ids, []int64{3, 4}) {
+		t.Errorf("Select() of values = %v, %v, want [3 4]", ids, err)
+	}
+
+	mock.ExpectQuery("SELECT id FROM ad").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	ids = nil
+	if err := Select(ctx, db, &ids, "SELECT id FROM ad"); err != nil || ids == nil || len(ids) != 0 {
+		t.Errorf("Select() without rows = %#v, %v, want an empty slice", ids, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	RegisterMockDb("scan_get")
+	db := Db("scan_get")
+	mock := DbMock("scan_get")
+
+	mock.ExpectQuery("SELECT id, title FROM ad").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"ID", "Title"}).AddRow(1, "bike").AddRow(2, "car"))
+	var ad scanAd
+	if err := Get(ctx, db, &ad, "SELECT id, title FROM ad WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ad.ID != 1 || ad.Title != "bike" {
+		t.Errorf("Get() = %+v, want the first row", ad)
+	}
+
+	mock.ExpectQuery("SELECT id, title FROM ad").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}))
+	if err := Get(ctx, db, &ad, "SELECT id, title FROM ad WHERE id = ?", 3); err != sql.ErrNoRows {
+		t.Errorf("Get() without rows = %v, want sql.ErrNoRows", err)
+	}
+
+	var created time.Time
+	at := time.Date(2020, 2, 29, 13, 14, 15, 0, time.UTC)
+	mock.ExpectQuery("SELECT created_at FROM ad").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(at))
+	if err := Get(ctx, db, &created, "SELECT created_at FROM ad"); err != nil || !created.Equal(at) {
+		t.Errorf("Get() of a time = %v, %v, want %v", created, err, at)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	ctx := context.Background()
+	RegisterMockDb("scan_errors")
+	db := Db("scan_errors")
+	mock := DbMock("scan_errors")
+
+	tests := []struct {
+		name    string
+		columns []string
+		dest    interface{}
+		want    string
+	}{
+		{"unknown column", []string{"id", "color"}, new([]scanAd), "no field of wconnectors.scanAd for the column color"},
+		{"skipped field", []string{"secret"}, new(scanAd), "no field of wconnectors.scanAd for the column secret"},
+		{"columns of a value", []string{"id", "title"}, new([]int64), "2 columns cannot be scanned into a int64"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(test.columns))
+			var err error
+			if _, ok := test.dest.(*scanAd); ok {
+				err = Get(ctx, db, test.dest, "SELECT")
+			} else {
+				err = Select(ctx, db, test.dest, "SELECT")
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error = %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	var ads []scanAd
+	if err := Select(ctx, db, ads, "SELECT"); err == nil {
+		t.Error("Select() into a slice = nil, want an error")
+	}
+	if err := Get(ctx, db, scanAd{}, "SELECT"); err == nil {
+		t.Error("Get() into a struct = nil, want an error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,338 @@
+package wconnectors
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/webediads/adsgolib/wconfig"
+)
+
+// default pool settings, the ones we used before they were configurable
+const (
+	defaultDbMaxOpenConns    = 100
+	defaultDbConnMaxLifetime = time.Minute
+)
+
+// dbSettingsFromConfig reads the settings of a connection from the [db] section, ex for the "main" connection:
+//
+//	main.driver = postgres (default mysql), see DbDriverPostgres
+//	main.username, main.password, main.host, main.port (default 3306, 5432 with postgres), main.database (the file with sqlite)
+//	main.socket = /var/run/mysqld/mysqld.sock, replaces host and port
+//	main.max_open_conns = 100, main.max_idle_conns = 10, main.conn_max_idle_time = 5m, main.conn_max_lifetime = 1m
+//	main.connect_timeout = 5s, main.read_timeout = 30s, main.write_timeout = 30s
+//	main.charset = utf8mb4, main.collation = utf8mb4_unicode_ci, main.loc = Europe/Paris, main.tls = skip-verify
+//	main.params = sql_mode=TRADITIONAL&autocommit=true
+//	main.replicas = replica1:3306,replica2, main.replica_balancing = least_conns, main.max_replica_lag = 30s, main.replica_check_interval = 10s
+//	main.instrument = true, main.slow_query_threshold = 500ms
+//	main.breaker_threshold = 5, main.breaker_cooldown = 10s
+//	main.fixture = testdata/main.json, main.fixture_mode = replay
+func dbSettingsFromConfig(name string) (DbSettings, error) {
+	settings := DbSettings{
+		Username:  dbConfigValue(name, "username"),
+		Password:  dbConfigValue(name, "password"),
+		Host:      dbConfigValue(name, "host"),
+		Port:      dbConfigValue(name, "port"),
+		Database:  dbConfigValue(name, "database"),
+		Socket:    dbConfigValue(name, "socket"),
+		Charset:   dbConfigValue(name, "charset"),
+		Collation: dbConfigValue(name, "collation"),
+		Loc:       dbConfigValue(name, "loc"),
+		TLS:       dbConfigValue(name, "tls"),
+
+		ReplicaBalancing: dbConfigValue(name, "replica_balancing"),
+
+		Driver: dbConfigValue(name, "driver"),
+
+		Fixture:     dbConfigValue(name, "fixture"),
+		FixtureMode: dbConfigValue(name, "fixture_mode"),
+	}
+	if replicas := dbConfigValue(name, "replicas"); strings.TrimSpace(replicas) != "" {
+		for _, replica := range strings.Split(replicas, ",") {
+			settings.Replicas = append(settings.Replicas, strings.TrimSpace(replica))
+		}
+	}
+
+	var err error
+	intKeys := map[string]*int{
+		"max_open_conns": &settings.MaxOpenConns,
+		"max_idle_conns": &settings.MaxIdleConns,
+
+		"breaker_threshold": &settings.BreakerThreshold,
+	}
+	for key, value := range intKeys {
+		if str := dbConfigValue(name, key); str != "" {
+			if *value, err = strconv.Atoi(str); err != nil {
+				return settings, fmt.Errorf("%s.%s: %q is not a number", name, key, str)
+			}
+		}
+	}
+	durationKeys := map[string]*time.Duration{
+		"conn_max_idle_time": &settings.ConnMaxIdleTime,
+		"conn_max_lifetime":  &settings.ConnMaxLifetime,
+		"connect_timeout":    &settings.ConnectTimeout,
+		"read_timeout":       &settings.ReadTimeout,
+		"write_timeout":      &settings.WriteTimeout,
+
+		"max_replica_lag":        &settings.MaxReplicaLag,
+		"replica_check_interval": &settings.ReplicaCheckInterval,
+
+		"slow_query_threshold": &settings.SlowQueryThreshold,
+		"breaker_cooldown":     &settings.BreakerCooldown,
+	}
+	for key, value := range durationKeys {
+		if str := dbConfigValue(name, key); str != "" {
+			if *value, err = time.ParseDuration(str); err != nil {
+				return settings, fmt.Errorf("%s.%s: %q is not a duration (ex: 30s)", name, key, str)
+			}
+		}
+	}
+
+	if str := dbConfigValue(name, "instrument"); str != "" {
+		if settings.Instrument, err = strconv.ParseBool(str); err != nil {
+			return settings, fmt.Errorf("%s.instrument: %q is not a boolean", name, str)
+		}
+	}
+
+	if str := dbConfigValue(name, "params"); str != "" {
+		params, err := url.ParseQuery(str)
+		if err != nil {
+			return settings, fmt.Errorf("%s.params: %s", name, err.Error())
+		}
+		settings.Params = make(map[string]string, len(params))
+		for key := range params {
+			settings.Params[key] = params.Get(key)
+		}
+	}
+
+	return settings, nil
+}
+
+// dbConfigValue returns an optional key of the [db] section, "" if it is missing
+func dbConfigValue(name string, key string) string {
+	value, err := wconfig.Config.Get("db", name+"."+key)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// validate checks the settings and sets the default values
+func (settings *DbSettings) validate() error {
+	if settings.IsMock {
+		return nil
+	}
+
+	switch settings.FixtureMode {
+	case "", DbFixtureRecord, DbFixtureReplay:
+	default:
+		return fmt.Errorf("invalid fixture mode %q, %s or %s expected", settings.FixtureMode, DbFixtureRecord, DbFixtureReplay)
+	}
+	if settings.FixtureMode != "" && settings.Fixture == "" {
+		return errors.New("fixture is required by the fixture mode")
+	}
+	if settings.FixtureMode == DbFixtureReplay {
+		// there is no server
+		return nil
+	}
+	if err := settings.validateDriver(); err != nil {
+		return err
+	}
+
+	if settings.Username == "" && settings.Driver != DbDriverSQLite {
+		return errors.New("username is required")
+	}
+	if settings.Database == "" {
+		return errors.New("database is required")
+	}
+	if settings.Socket == "" && settings.Driver != DbDriverSQLite {
+		if settings.Host == "" {
+			return errors.New("host or socket is required")
+		}
+		if settings.Port == "" {
+			settings.Port = defaultDbPorts[settings.Driver]
+		}
+		if port, err := strconv.Atoi(settings.Port); err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %q", settings.Port)
+		}
+	}
+
+	if settings.MaxOpenConns < 0 {
+		return fmt.Errorf("max open conns cannot be negative: %d", settings.MaxOpenConns)
+	}
+	if settings.MaxOpenConns == 0 {
+		settings.MaxOpenConns = defaultDbMaxOpenConns
+		if settings.Driver == DbDriverSQLite && settings.Database == ":memory:" {
+			// every connection has its own database in memory
+			settings.MaxOpenConns = 1
+		}
+	}
+	if settings.MaxIdleConns > settings.MaxOpenConns {
+		return fmt.Errorf("max idle conns (%d) cannot be above max open conns (%d)", settings.MaxIdleConns, settings.MaxOpenConns)
+	}
+	if settings.ConnMaxLifetime == 0 && settings.Driver != DbDriverSQLite {
+		settings.ConnMaxLifetime = defaultDbConnMaxLifetime
+	}
+	durations := map[string]time.Duration{
+		"conn max idle time":   settings.ConnMaxIdleTime,
+		"conn max lifetime":    settings.ConnMaxLifetime,
+		"connect timeout":      settings.ConnectTimeout,
+		"read timeout":         settings.ReadTimeout,
+		"write timeout":        settings.WriteTimeout,
+		"slow query threshold": settings.SlowQueryThreshold,
+		"breaker cooldown":     settings.BreakerCooldown,
+	}
+	for durationName, duration := range durations {
+		if duration < 0 {
+			return fmt.Errorf("%s cannot be negative: %s", durationName, duration)
+		}
+	}
+
+	if settings.Loc != "" {
+		if _, err := time.LoadLocation(settings.Loc); err != nil {
+			return fmt.Errorf("invalid loc %q: %s", settings.Loc, err.Error())
+		}
+	}
+	for key := range settings.Params {
+		if key == "" {
+			return errors.New("params cannot have an empty key")
+		}
+	}
+
+	if settings.SlowQueryThreshold > 0 {
+		settings.Instrument = true
+	}
+	if settings.BreakerThreshold < 0 {
+		return fmt.Errorf("breaker threshold cannot be negative: %d", settings.BreakerThreshold)
+	}
+
+	if err := settings.validateReplicas(); err != nil {
+		return err
+	}
+
+	if settings.Driver != DbDriverMySQL {
+		// the other drivers check their dsn when they connect
+		return nil
+	}
+	// the driver checks the rest, the tls profile included
+	cfg, err := settings.mysqlConfig()
+	if err != nil {
+		return err
+	}
+	if _, err := mysql.ParseDSN(cfg.FormatDSN()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// mysqlConfig returns the driver configuration of the settings, they must have been validated
+func (settings *DbSettings) mysqlConfig() (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = settings.Username
+	cfg.Passwd = settings.Password
+	cfg.DBName = settings.Database
+	cfg.ParseTime = true
+	if settings.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = settings.Socket
+	} else {
+		cfg.Net = "tcp"
+		cfg.Addr = settings.Host + ":" + settings.Port
+	}
+	cfg.Timeout = settings.ConnectTimeout
+	cfg.ReadTimeout = settings.ReadTimeout
+	cfg.WriteTimeout = settings.WriteTimeout
+	cfg.TLSConfig = settings.TLS
+	if settings.Collation != "" {
+		cfg.Collation = settings.Collation
+	}
+	if settings.Loc != "" {
+		loc, err := time.LoadLocation(settings.Loc)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Loc = loc
+	}
+
+	if settings.Charset != "" || len(settings.Params) > 0 {
+		cfg.Params = make(map[string]string, len(settings.Params)+1)
+		for key, value := range settings.Params {
+			cfg.Params[key] = value
+		}
+		if settings.Charset != "" {
+			cfg.Params["charset"] = settings.Charset
+		}
+	}
+	return cfg, nil
+}
+
+// dsn returns the connection string of the settings for their driver, they must have been validated
+func (settings *DbSettings) dsn() string {
+	if settings.isPostgres() {
+		return settings.postgresDSN()
+	}
+	if settings.Driver == DbDriverSQLite {
+		return settings.sqliteDSN()
+	}
+	cfg, err := settings.mysqlConfig()
+	if err != nil {
+		return ""
+	}
+	return cfg.FormatDSN()
+}
+
+// openDb opens a connection with the settings, wrapped by the instrumented connector if Instrument is set,
+// if the exchanges are recorded or if the connection has a breaker
+func openDb(name string, settings DbSettings, breaker *Breaker) (*sql.DB, error) {
+	var db *sql.DB
+	if settings.Instrument || settings.FixtureMode == DbFixtureRecord || breaker != nil {
+		var connector driver.Connector
+		if settings.Driver == DbDriverMySQL {
+			cfg, err := settings.mysqlConfig()
+			if err != nil {
+				return nil, err
+			}
+			if connector, err = mysql.NewConnector(cfg); err != nil {
+				return nil, err
+			}
+		} else {
+			var err error
+			if connector, err = driverConnector(settings.Driver, settings.dsn()); err != nil {
+				return nil, err
+			}
+		}
+		db = sql.OpenDB(&dbConnector{connector: connector, observer: observerFor(name, settings), breaker: breaker})
+	} else {
+		var err error
+		db, err = sql.Open(settings.Driver, settings.dsn())
+		if err != nil {
+			return nil, err
+		}
+	}
+	settings.configurePool(db)
+	return db, nil
+}
+
+// breaker returns the circuit breaker of a server of the connection, nil if BreakerThreshold is not set
+func (settings *DbSettings) breaker(name string) *Breaker {
+	if settings.BreakerThreshold <= 0 {
+		return nil
+	}
+	return NewBreaker(name, BreakerSettings{FailureThreshold: settings.BreakerThreshold, Cooldown: settings.BreakerCooldown})
+}
+
+// configurePool applies the pool settings to a connection
+func (settings *DbSettings) configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(settings.MaxOpenConns)
+	if settings.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(settings.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(settings.ConnMaxLifetime)
+	setConnMaxIdleTime(db, settings.ConnMaxIdleTime)
+}
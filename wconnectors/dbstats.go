@@ -0,0 +1,213 @@
+package wconnectors
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// QueryStats holds the counters of a normalized query
+type QueryStats struct {
+	Query         string        `json:"query"` // normalized: the values are replaced with ?
+	Count         int64         `json:"count"`
+	Errors        int64         `json:"errors"`
+	Rows          int64         `json:"rows"` // rows read or affected
+	TotalDuration time.Duration `json:"total_duration"`
+	MaxDuration   time.Duration `json:"max_duration"`
+}
+
+// maxQueryStats is the number of normalized queries we keep the counters of per connection,
+// the next ones are counted together
+const maxQueryStats = 1000
+
+// otherQueries is the query of the counters of the queries beyond maxQueryStats
+const otherQueries = "(other queries)"
+
+// dbObserver records the counters of the queries of a connection and logs the slow ones
+type dbObserver struct {
+	name               string
+	instrument         bool
+	slowQueryThreshold time.Duration
+	recorder           *dbRecorder // nil unless the exchanges are recorded
+	mutex              sync.Mutex
+	stats              map[string]*QueryStats
+}
+
+var dbObservers = make(map[string]*dbObserver)
+var dbObserversMutex sync.Mutex
+
+// observerFor returns the observer of a connection, it is created on first use and shared with the replicas
+func observerFor(name string, settings DbSettings) *dbObserver {
+	dbObserversMutex.Lock()
+	defer dbObserversMutex.Unlock()
+	observer, ok := dbObservers[name]
+	if !ok {
+		observer = &dbObserver{name: name, stats: make(map[string]*QueryStats)}
+		dbObservers[name] = observer
+	}
+	observer.instrument = settings.Instrument
+	observer.slowQueryThreshold = settings.SlowQueryThreshold
+	if settings.FixtureMode == DbFixtureRecord && (observer.recorder == nil || observer.recorder.path != settings.Fixture) {
+		if observer.recorder != nil {
+			// the previous recording is complete
+			observer.recorder.flush()
+		}
+		observer.recorder = newDbRecorder(settings.Fixture)
+	}
+	return observer
+}
+
+// DbQueryStats returns the counters of the queries of the instrumented connections, by connection name,
+// the most time consuming queries first. The replicas are counted along with their primary
+func DbQueryStats() map[string][]QueryStats {
+	dbObserversMutex.Lock()
+	defer dbObserversMutex.Unlock()
+	allStats := make(map[string][]QueryStats, len(dbObservers))
+	for name, observer := range dbObservers {
+		if !observer.instrument {
+			continue
+		}
+		observer.mutex.Lock()
+		stats := make([]QueryStats, 0, len(observer.stats))
+		for _, queryStats := range observer.stats {
+			stats = append(stats, *queryStats)
+		}
+		observer.mutex.Unlock()
+		sort.Slice(stats, func(i, j int) bool {
+			return stats[i].TotalDuration > stats[j].TotalDuration
+		})
+		allStats[name] = stats
+	}
+	return allStats
+}
+
+// ResetDbQueryStats resets the counters of the queries, ex: after they were exported
+func ResetDbQueryStats() {
+	dbObserversMutex.Lock()
+	defer dbObserversMutex.Unlock()
+	for _, observer := range dbObservers {
+		observer.mutex.Lock()
+		observer.stats = make(map[string]*QueryStats)
+		observer.mutex.Unlock()
+	}
+}
+
+// observeResult observes a query that returned a result
+func (observer *dbObserver) observeResult(query string, args []driver.NamedValue, start time.Time, result driver.Result, err error) {
+	var rows int64
+	if err == nil && result != nil {
+		rows, _ = result.RowsAffected()
+	}
+	observer.observe(query, time.Since(start), rows, err)
+	if observer.recorder != nil {
+		observer.recorder.recordResult(query, args, result, err)
+	}
+}
+
+// observeRows observes a query that returned rows once they are closed, or right away if it failed
+func (observer *dbObserver) observeRows(query string, args []driver.NamedValue, start time.Time, rows driver.Rows, err error) (driver.Rows, error) {
+	if err != nil {
+		observer.observe(query, time.Since(start), 0, err)
+		if observer.recorder != nil {
+			observer.recorder.recordRows(query, args, nil, nil, err)
+		}
+		return nil, err
+	}
+	return &dbRows{rows: rows, query: query, args: args, start: start, observer: observer}, nil
+}
+
+// observe counts a query and logs it if it is slow
+func (observer *dbObserver) observe(query string, duration time.Duration, rows int64, err error) {
+	if !observer.instrument {
+		return
+	}
+	normalized := normalizeQuery(query)
+
+	observer.mutex.Lock()
+	stats, ok := observer.stats[normalized]
+	if !ok {
+		if len(observer.stats) >= maxQueryStats {
+			normalized = otherQueries
+			stats = observer.stats[normalized]
+		}
+		if stats == nil {
+			stats = &QueryStats{Query: normalized}
+			observer.stats[normalized] = stats
+		}
+	}
+	stats.Count++
+	stats.Rows += rows
+	stats.TotalDuration += duration
+	if duration > stats.MaxDuration {
+		stats.MaxDuration = duration
+	}
+	if err != nil {
+		stats.Errors++
+	}
+	observer.mutex.Unlock()
+
+	if observer.slowQueryThreshold > 0 && duration >= observer.slowQueryThreshold {
+		wlog.Log(wlog.LevelWarning, fmt.Sprintf("slow query on %s (%s): %s", observer.name, duration.Round(time.Millisecond), normalized),
+			wlog.Skip(dbCallerSkip()),
+			wlog.Fields(map[string]interface{}{
+				"db":          observer.name,
+				"query":       normalized,
+				"duration_ms": duration.Milliseconds(),
+				"rows":        rows,
+			}),
+		)
+	}
+}
+
+// dbCallerSkip returns the number of frames between its caller and the code that ran the query,
+// the frames of database/sql and of this package are skipped
+func dbCallerSkip() int {
+	pcs := make([]uintptr, 64)
+	// skip runtime.Callers and dbCallerSkip
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for skip := 0; ; skip++ {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "database/sql.") &&
+			!strings.HasPrefix(frame.Function, "github.com/webediads/adsgolib/wconnectors.") &&
+			!strings.HasPrefix(frame.Function, "runtime.") {
+			return skip
+		}
+		if !more {
+			return 0
+		}
+	}
+}
+
+var queryNormalizations = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// comments
+	{regexp.MustCompile(`(?s)/\*.*?\*/`), ""},
+	// strings, with their escaped quotes
+	{regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`), "?"},
+	// numbers, but not the digits of the identifiers
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b\d+(?:\.\d+)?\b`), "?"},
+	{regexp.MustCompile(`\s+`), " "},
+	// IN (?, ?, ?)
+	{regexp.MustCompile(`(?i)\bIN ?\( ?\?(?: ?, ?\?)* ?\)`), "IN (?+)"},
+	// VALUES (?, ?), (?, ?)
+	{regexp.MustCompile(`(\( ?\?(?: ?, ?\?)* ?\))(?: ?, ?\( ?\?(?: ?, ?\?)* ?\))+`), "$1, ..."},
+}
+
+// normalizeQuery replaces the values of a query with ? so that the queries differing by their values are counted together
+// ex : SELECT * FROM user WHERE id IN (1, 2, 3) AND name = 'bob' becomes SELECT * FROM user WHERE id IN (?+) AND name = ?
+func normalizeQuery(query string) string {
+	for _, normalization := range queryNormalizations {
+		query = normalization.pattern.ReplaceAllString(query, normalization.replacement)
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+}
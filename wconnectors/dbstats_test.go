@@ -0,0 +1,26 @@
+package wconnectors
+
+import (
+	"testing"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM user WHERE id IN (1, 2, 3) AND name = 'bob'", "SELECT * FROM user WHERE id IN (?+) AND name = ?"},
+		{"SELECT * FROM user WHERE id = ?", "SELECT * FROM user WHERE id = ?"},
+		{"SELECT * FROM user WHERE id IN (?, ?)", "SELECT * FROM user WHERE id IN (?+)"},
+		{"SELECT * FROM user WHERE id in(4,5)", "SELECT * FROM user WHERE id IN (?+)"},
+		{"SELECT * FROM ad2 WHERE price > 1.5 AND flags = 0x1F", "SELECT * FROM ad2 WHERE price > ? AND flags = ?"},
+		{"SELECT * FROM user WHERE name = 'o\\'neil' OR name = 'o''neil'", "SELECT * FROM user WHERE name = ? OR name = ?"},
+		{"INSERT INTO ad_event (ad_id, views) VALUES (1, 10), (2, 20), (3, 30)", "INSERT INTO ad_event (ad_id, views) VALUES (?, ?), ..."},
+		{"/* report */ SELECT  *\n\tFROM user ;", "SELECT * FROM user"},
+	}
+	for _, test := range tests {
+		if got := normalizeQuery(test.query); got != test.want {
+			t.Errorf("normalizeQuery(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
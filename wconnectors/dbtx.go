@@ -0,0 +1,231 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/webediads/adsgolib/wcontext"
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// default transaction settings
+const (
+	defaultTxMaxRetries = 3
+	defaultTxMinBackoff = 10 * time.Millisecond
+	defaultTxMaxBackoff = time.Second
+)
+
+// mysql errors after which the transaction can be run again
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// postgres errors after which the transaction can be run again
+const (
+	postgresErrSerializationFailure = "40001"
+	postgresErrDeadlock             = "40P01"
+)
+
+// sqlStateError is implemented by the errors of the postgres drivers, lib/pq and pgx
+type sqlStateError interface {
+	SQLState() string
+}
+
+// TxOptions are the options of WithTx, nil for the default ones
+type TxOptions struct {
+	Isolation  sql.IsolationLevel // default: the one of the server
+	ReadOnly   bool
+	MaxRetries int           // default 3, negative for none
+	MinBackoff time.Duration // default 10ms, the wait before the first retry, doubled at every retry
+	MaxBackoff time.Duration // default 1s
+}
+
+// dbTx is a transaction of WithTx, along with what the nested calls need
+type dbTx struct {
+	name       string
+	tx         *sql.Tx
+	savepoints int
+}
+
+var activeTxs = make(map[*sql.Tx]*dbTx)
+var activeTxsMutex sync.Mutex
+
+// WithTx runs fn in a transaction: it is committed if fn returns nil, rolled back if fn returns an error or panics.
+// The transaction is run again, after a jittered backoff, when it fails on a deadlock, a lock wait timeout
+// or a bad connection.
+// When ctx carries a transaction of the same connection (see TxContext), fn runs in a savepoint of that transaction
+// instead, so that library code can compose transactions: an error only rolls back to the savepoint, and the retries
+// are left to the outermost call
+// ex : err := wconnectors.WithTx(ctx, "main", nil, func(tx *sql.Tx) error { _, err := tx.ExecContext(ctx, "UPDATE ..."); return err })
+func WithTx(ctx context.Context, dbName string, opts *TxOptions, fn func(tx *sql.Tx) error) error {
+	if outer, ok := ctx.Value(wcontext.ContextKeyTx).(*dbTx); ok && outer.name == dbName {
+		return outer.withSavepoint(ctx, fn)
+	}
+
+	db, err := DbE(dbName)
+	if err != nil {
+		return err
+	}
+
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultTxMaxRetries
+	}
+	minBackoff, maxBackoff := opts.MinBackoff, opts.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultTxMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultTxMaxBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = runTx(ctx, dbName, db, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}, fn)
+		if err == nil || attempt >= maxRetries || !isRetryableTxError(err) {
+			return err
+		}
+
+		backoff := minBackoff << uint(attempt)
+		if backoff > maxBackoff || backoff <= 0 {
+			backoff = maxBackoff
+		}
+		// full jitter, so that the transactions that deadlocked together do not run again together
+		backoff = time.Duration(rand.Int63n(int64(backoff) + 1))
+		wlog.Log(wlog.LevelNotice, fmt.Sprintf("db %s: transaction retried in %s: %s", dbName, backoff.Round(time.Millisecond), err.Error()),
+			wlog.Field("db", dbName), wlog.Field("attempt", attempt+1))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// TxContext returns a context carrying a transaction of WithTx, the calls of WithTx with this context run in
+// savepoints of the transaction. It returns ctx as is if tx was not started by WithTx
+// ex : wconnectors.WithTx(ctx, "main", nil, func(tx *sql.Tx) error { return orders.Create(wconnectors.TxContext(ctx, tx), order) })
+func TxContext(ctx context.Context, tx *sql.Tx) context.Context {
+	activeTxsMutex.Lock()
+	active, ok := activeTxs[tx]
+	activeTxsMutex.Unlock()
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, wcontext.ContextKeyTx, active)
+}
+
+// runTx runs fn in a new transaction
+func runTx(ctx context.Context, dbName string, db *sql.DB, txOptions *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, txOptions)
+	if err != nil {
+		return err
+	}
+
+	activeTxsMutex.Lock()
+	activeTxs[tx] = &dbTx{name: dbName, tx: tx}
+	activeTxsMutex.Unlock()
+	defer func() {
+		activeTxsMutex.Lock()
+		delete(activeTxs, tx)
+		activeTxsMutex.Unlock()
+	}()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback()
+			panic(recovered)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			wlog.LogError(wlog.LevelWarning, wlog.Wrap(rollbackErr, "db "+dbName+": rollback"), wlog.Field("db", dbName))
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		if errors.Is(err, driver.ErrBadConn) {
+			// the commit may have gone through, running the transaction again could apply it twice
+			return &txCommitError{dbName: dbName, err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// txCommitError is a commit that failed on a bad connection, it may have gone through so it is not retried
+type txCommitError struct {
+	dbName string
+	err    error
+}
+
+func (commitErr *txCommitError) Error() string {
+	return "wconnectors: db " + commitErr.dbName + ": commit: " + commitErr.err.Error()
+}
+
+func (commitErr *txCommitError) Unwrap() error {
+	return commitErr.err
+}
+
+// withSavepoint runs fn in a savepoint of the transaction
+func (active *dbTx) withSavepoint(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	active.savepoints++
+	savepoint := "wtx_" + strconv.Itoa(active.savepoints)
+	if _, err := active.tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			active.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+			panic(recovered)
+		}
+	}()
+
+	if err := fn(active.tx); err != nil {
+		// after a deadlock the server already rolled back the whole transaction, the error goes up to the outermost call
+		if !isRetryableTxError(err) {
+			if _, rollbackErr := active.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); rollbackErr != nil {
+				wlog.LogError(wlog.LevelWarning, wlog.Wrap(rollbackErr, "db "+active.name+": rollback to savepoint"), wlog.Field("db", active.name))
+			}
+		}
+		return err
+	}
+	_, err := active.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+	return err
+}
+
+// isRetryableTxError tells whether a transaction that failed with err can be run again
+func isRetryableTxError(err error) bool {
+	var commitErr *txCommitError
+	if errors.As(err, &commitErr) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState() == postgresErrSerializationFailure || stateErr.SQLState() == postgresErrDeadlock
+	}
+	return false
+}
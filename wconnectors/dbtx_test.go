@@ -0,0 +1,147 @@
+package wconnectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+// newTxMock registers a mocked db for a test of the transactions
+func newTxMock(t *testing.T, name string) sqlmock.Sqlmock {
+	RegisterMockDb(name)
+	if _, err := DbE(name); err != nil {
+		t.Fatal(err)
+	}
+	return DbMock(name)
+}
+
+func TestWithTx(t *testing.T) {
+	errFailed := errors.New("failed")
+	errDeadlock := &mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found"}
+	errLockWait := &mysql.MySQLError{Number: mysqlErrLockWaitTimeout, Message: "Lock wait timeout exceeded"}
+	errDuplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	opts := &TxOptions{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	tests := []struct {
+		name      string
+		opts      *TxOptions
+		expect    func(mock sqlmock.Sqlmock)
+		outcomes  []error // what fn returns at each attempt
+		wantErr   error
+		wantCalls int
+	}{
+		{"commit on success", opts, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectCommit()
+		}, []error{nil}, nil, 1},
+		{"rollback on error", opts, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+		}, []error{errFailed}, errFailed, 1},
+		{"retry on deadlock", opts, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectCommit()
+		}, []error{errDeadlock, nil}, nil, 2},
+		{"retry on lock wait timeout up to MaxRetries", opts, func(mock sqlmock.Sqlmock) {
+			for i := 0; i < 3; i++ {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			}
+		}, []error{errLockWait, errLockWait, errLockWait}, errLockWait, 3},
+		{"no retry when negative", &TxOptions{MaxRetries: -1}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+		}, []error{errDeadlock}, errDeadlock, 1},
+		{"no retry on other errors", opts, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+		}, []error{errDuplicate}, errDuplicate, 1},
+		{"no retry on a commit on a bad connection", opts, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectCommit().WillReturnError(driver.ErrBadConn)
+		}, []error{nil}, driver.ErrBadConn, 1},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := "tx_" + strconv.Itoa(i)
+			mock := newTxMock(t, name)
+			test.expect(mock)
+
+			calls := 0
+			err := WithTx(context.Background(), name, test.opts, func(tx *sql.Tx) error {
+				calls++
+				return test.outcomes[calls-1]
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("WithTx() = %v, want %v", err, test.wantErr)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, test.wantCalls)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestWithTxPanic(t *testing.T) {
+	mock := newTxMock(t, "tx_panic")
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	defer func() {
+		if recovered := recover(); recovered != "boom" {
+			t.Errorf("recovered %v, want boom", recovered)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}()
+	WithTx(context.Background(), "tx_panic", nil, func(tx *sql.Tx) error {
+		panic("boom")
+	})
+}
+
+func TestWithTxSavepoints(t *testing.T) {
+	errFailed := errors.New("failed")
+	mock := newTxMock(t, "tx_nested")
+	mock.ExpectBegin()
+	mock.ExpectExec("^SAVEPOINT wtx_1$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^RELEASE SAVEPOINT wtx_1$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^SAVEPOINT wtx_2$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^ROLLBACK TO SAVEPOINT wtx_2$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := WithTx(context.Background(), "tx_nested", nil, func(tx *sql.Tx) error {
+		ctx := TxContext(context.Background(), tx)
+		if err := WithTx(ctx, "tx_nested", nil, func(nested *sql.Tx) error {
+			if nested != tx {
+				t.Error("the nested call got another transaction")
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		// the failure of a nested call only rolls back its savepoint
+		if err := WithTx(ctx, "tx_nested", nil, func(*sql.Tx) error { return errFailed }); err != errFailed {
+			t.Errorf("nested WithTx() = %v, want %v", err, errFailed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("WithTx() = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
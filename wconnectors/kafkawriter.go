@@ -13,9 +13,11 @@ var kafkaWriterOnce map[string]bool
 var kafkaWriterOnceMutex sync.Mutex
 
 // KafkaWriterSettings is the struct that is used for registering a connection
+// Balancer defaults to kafka.LeastBytes, use &kafka.Hash{} to keep the messages sharing a key in order
 type KafkaWriterSettings struct {
-	Topic   string
-	Brokers []string
+	Topic    string
+	Brokers  []string
+	Balancer kafka.Balancer
 }
 
 var allKafkaWriterSettings = make(map[string]KafkaWriterSettings)
@@ -33,25 +35,28 @@ func KafkaWriter(topicName string) *kafka.Writer {
 		return nil
 	}
 
+	kafkaWriterOnceMutex.Lock()
+	defer kafkaWriterOnceMutex.Unlock()
 	if len(kafkaWriterOnce) == 0 {
 		kafkaWriterOnce = make(map[string]bool, 15)
 		kafkaWriterConnections = make(map[string]*kafka.Writer, 15)
 	}
 
-	kafkaWriterOnceMutex.Lock()
 	if !kafkaWriterOnce[topicName] {
 		kafkaWriterOnce[topicName] = true
 
+		balancer := allKafkaWriterSettings[topicName].Balancer
+		if balancer == nil {
+			balancer = &kafka.LeastBytes{}
+		}
+
 		kkConnection := kafka.NewWriter(kafka.WriterConfig{
 			Brokers:      allKafkaWriterSettings[topicName].Brokers,
 			Topic:        allKafkaWriterSettings[topicName].Topic,
-			Balancer:     &kafka.LeastBytes{},
+			Balancer:     balancer,
 			BatchTimeout: 10 * time.Millisecond,
 		})
 		kafkaWriterConnections[topicName] = kkConnection
-		kafkaWriterOnceMutex.Unlock()
-	} else {
-		kafkaWriterOnceMutex.Unlock()
 	}
 	return kafkaWriterConnections[topicName]
 }
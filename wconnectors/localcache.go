@@ -213,16 +213,16 @@ func LocalCache(name string) Cache {
 		panic("This LocalCache '" + name + "' was not registered")
 	}
 
+	cacheOnceMutex.Lock()
+	defer cacheOnceMutex.Unlock()
 	if len(cacheOnce) == 0 {
 		cacheOnce = make(map[string]bool, 50)
 		cacheConnections = make(map[string]Cache, 50)
 	}
-	cacheOnceMutex.Lock()
 	if !cacheOnce[name] {
 		cacheOnce[name] = true
 		cacheConnections[name] = NewLocked(localCacheSettings.Size, localCacheSettings.TTL)
 	}
-	cacheOnceMutex.Unlock()
 	return cacheConnections[name]
 
 }
@@ -24,11 +24,13 @@ type memcacheHostSettings struct {
 type memcacheConnectionSettings []memcacheHostSettings
 
 var allMemcacheSettings = make(map[string]memcacheConnectionSettings)
+var allMemcacheBreakerSettings = make(map[string]BreakerSettings)
 
 // MemcacheConnection is our abstraction to memcache.Client
 type MemcacheConnection struct {
 	settings memcacheConnectionSettings
 	client   *memcache.Client
+	breaker  *Breaker
 }
 
 // Memcache returns a memcache client connection
@@ -39,12 +41,13 @@ func Memcache(name string) *MemcacheConnection {
 		return nil
 	}
 
+	memcacheOnceMutex.Lock()
+	defer memcacheOnceMutex.Unlock()
 	if len(memcacheOnce) == 0 {
 		memcacheOnce = make(map[string]bool, 15)
 		memcacheConnections = make(map[string]*MemcacheConnection, 15)
 	}
 
-	memcacheOnceMutex.Lock()
 	if !memcacheOnce[name] {
 		memcacheOnce[name] = true
 		mcConnection := new(MemcacheConnection)
@@ -65,17 +68,26 @@ func Memcache(name string) *MemcacheConnection {
 			memcacheClient.MaxIdleConns = 50000              // default: 2
 			mcConnection.client = memcacheClient
 			mcConnection.settings = allMemcacheSettings[name]
+			if breakerSettings, ok := allMemcacheBreakerSettings[name]; ok {
+				mcConnection.breaker = NewBreaker("memcache "+name, breakerSettings)
+			}
 			memcacheConnections[name] = mcConnection
 		}
 	}
-	memcacheOnceMutex.Unlock()
 
 	return memcacheConnections[name]
 }
 
-// RegisterMemcache registers the settings for a connection name
+// RegisterMemcache registers the settings for a connection name, the calls go through a circuit breaker
+// if breaker settings are given, they return ErrBreakerOpen while it is open
 // ex : wconnectors.RegisterMemcache("global", wconfig.Config.GetUnsafe("cache", "memcache.global"))
-func RegisterMemcache(name string, settingsString string) {
+// ex : wconnectors.RegisterMemcache("global", settingsString, wconnectors.BreakerSettings{FailureThreshold: 10, Cooldown: 5 * time.Second})
+func RegisterMemcache(name string, settingsString string, breakerSettingsOpt ...BreakerSettings) {
+	if len(breakerSettingsOpt) > 0 {
+		allMemcacheBreakerSettings[name] = breakerSettingsOpt[0]
+	} else {
+		delete(allMemcacheBreakerSettings, name)
+	}
 	var newMemcacheConnectionSettings memcacheConnectionSettings
 	// if we receive an empty config
 	if strings.TrimSpace(settingsString) == "" {
@@ -102,7 +114,11 @@ func (memcacheConnection MemcacheConnection) Set(key string, value []byte, expir
 	}
 	// if the config is not empty
 	if memcacheConnection.client != nil {
+		if err := memcacheConnection.breaker.Allow(); err != nil {
+			return err
+		}
 		mcErr := memcacheConnection.client.Set(&memcache.Item{Key: key, Value: []byte(value), Expiration: int32(expirationSeconds)})
+		memcacheConnection.breaker.Done(mcErr)
 		if mcErr != nil {
 			wlog.GetLogger().Notice("memcache error set", nil, nil)
 		}
@@ -113,11 +129,40 @@ func (memcacheConnection MemcacheConnection) Set(key string, value []byte, expir
 	}
 }
 
+// Add stores a value unless the key is already set, memcache.ErrNotStored is returned then
+func (memcacheConnection MemcacheConnection) Add(key string, value []byte, expirationSecondsOpt ...int32) error {
+	var expirationSeconds int32
+	if len(expirationSecondsOpt) > 0 {
+		expirationSeconds = expirationSecondsOpt[0]
+	} else {
+		expirationSeconds = 3600
+	}
+	// if the config is not empty
+	if memcacheConnection.client != nil {
+		if err := memcacheConnection.breaker.Allow(); err != nil {
+			return err
+		}
+		mcErr := memcacheConnection.client.Add(&memcache.Item{Key: key, Value: value, Expiration: expirationSeconds})
+		memcacheConnection.breaker.Done(mcErr)
+		if mcErr != nil && mcErr != memcache.ErrNotStored {
+			wlog.GetLogger().Notice("memcache error add: "+mcErr.Error(), nil, nil)
+		}
+		return mcErr
+	} else {
+		// do nothing as intended with an empty config
+		return nil
+	}
+}
+
 // Get stores a value
 func (memcacheConnection MemcacheConnection) Get(key string) ([]byte, error) {
 	// if the config is not empty
 	if memcacheConnection.client != nil {
+		if err := memcacheConnection.breaker.Allow(); err != nil {
+			return []byte(""), err
+		}
 		i, err := memcacheConnection.client.Get(key)
+		memcacheConnection.breaker.Done(err)
 		if err != nil {
 			if err != memcache.ErrCacheMiss {
 				wlog.GetLogger().Notice("memcache error get: "+err.Error(), nil, nil)
@@ -134,7 +179,11 @@ func (memcacheConnection MemcacheConnection) Get(key string) ([]byte, error) {
 func (memcacheConnection MemcacheConnection) Delete(key string) (error) {
 	// if the config is not empty
 	if memcacheConnection.client != nil {
+		if err := memcacheConnection.breaker.Allow(); err != nil {
+			return err
+		}
 		err := memcacheConnection.client.Delete(key)
+		memcacheConnection.breaker.Done(err)
 		if err != nil {
 			if err != memcache.ErrCacheMiss {
 				wlog.GetLogger().Notice("memcache error delete: "+err.Error(), nil, nil)
@@ -0,0 +1,161 @@
+package wconnectors
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// connectorCloser closes an opened connection
+type connectorCloser struct {
+	name  string
+	close func() error
+}
+
+// Shutdown closes the opened connections before ctx is done: the bulk writers and the kafka writers are flushed first,
+// then the health checks are stopped and the db pools are closed, their recordings are written to the fixture files.
+// The memcache clients are dropped, the pinned gomemcache cannot close its idle connections, the garbage collector does.
+// The registries are emptied but the settings stay registered, so that the next calls open the connections again,
+// ex: between tests. The error lists the connections that failed or did not close in time
+// ex : ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//
+//	defer cancel()
+//	if err := wconnectors.Shutdown(ctx); err != nil { ... }
+func Shutdown(ctx context.Context) error {
+	var failures []string
+
+	// the bulk writers write to the dbs, they go before them
+	var closers []connectorCloser
+	bulkWritersMutex.Lock()
+	for writer := range bulkWriters {
+		writer := writer
+		closers = append(closers, connectorCloser{name: "bulk writer " + writer.table, close: func() error {
+			return writer.Close(ctx)
+		}})
+	}
+	bulkWritersMutex.Unlock()
+	failures = append(failures, closeAll(ctx, closers)...)
+
+	// the kafka writers send their buffered messages when they are closed
+	closers = nil
+	kafkaWriterOnceMutex.Lock()
+	for topicName, writer := range kafkaWriterConnections {
+		closers = append(closers, connectorCloser{name: "kafka writer " + topicName, close: writer.Close})
+	}
+	kafkaWriterOnce = nil
+	kafkaWriterConnections = nil
+	kafkaWriterOnceMutex.Unlock()
+	failures = append(failures, closeAll(ctx, closers)...)
+
+	dbHealthMonitorStopMutex.Lock()
+	stopDbHealthMonitor := dbHealthMonitorStop
+	dbHealthMonitorStop = nil
+	dbHealthMonitorStopMutex.Unlock()
+	if stopDbHealthMonitor != nil {
+		stopDbHealthMonitor()
+	}
+
+	// database/sql waits for the queries in progress
+	closers = nil
+	dbOnceMutex.Lock()
+	for name, db := range dbConnections {
+		db := db
+		closeDb := db.Close
+		if allDbSettings[name].IsMock {
+			// the mocks fail when the close was not expected
+			closeDb = func() error {
+				db.Close()
+				return nil
+			}
+		}
+		closers = append(closers, connectorCloser{name: "db " + name, close: closeDb})
+	}
+	for name, replicaSet := range dbReplicaSets {
+		close(replicaSet.stopCheck)
+		for _, replica := range replicaSet.replicas {
+			closers = append(closers, connectorCloser{name: "db " + name + " replica " + replica.addr, close: replica.db.Close})
+		}
+	}
+	dbOnce = nil
+	dbConnections = nil
+	dbMocks = nil
+	dbReplicaSets = make(map[string]*dbReplicaSet)
+	dbOnceMutex.Unlock()
+	failures = append(failures, closeAll(ctx, closers)...)
+
+	// database/sql only closes the connectors, which write the recordings, since go 1.17
+	closers = nil
+	dbObserversMutex.Lock()
+	for name, observer := range dbObservers {
+		if observer.recorder != nil {
+			closers = append(closers, connectorCloser{name: "db fixture " + name, close: observer.recorder.flush})
+		}
+	}
+	dbObserversMutex.Unlock()
+	failures = append(failures, closeAll(ctx, closers)...)
+
+	// Client.Close came with a gomemcache requiring go 1.18, the idle connections of ours are left to the garbage collector
+	memcacheOnceMutex.Lock()
+	memcacheOnce = nil
+	memcacheConnections = nil
+	memcacheOnceMutex.Unlock()
+
+	cacheOnceMutex.Lock()
+	cacheOnce = nil
+	cacheConnections = nil
+	cacheOnceMutex.Unlock()
+
+	// the state of the closed connections
+	breakersMutex.Lock()
+	breakers = make(map[string]*Breaker)
+	breakersMutex.Unlock()
+	dbObserversMutex.Lock()
+	dbObservers = make(map[string]*dbObserver)
+	dbObserversMutex.Unlock()
+	cachedQueryGenerationsMutex.Lock()
+	cachedQueryGenerations = make(map[string]cachedQueryGeneration)
+	cachedQueryGenerationsMutex.Unlock()
+
+	if len(failures) > 0 {
+		return fmt.Errorf("wconnectors: shutdown: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// closeAll runs the closers at once and returns the ones that failed or did not end before ctx,
+// the ones that did not end keep running
+func closeAll(ctx context.Context, closers []connectorCloser) []string {
+	type closeResult struct {
+		index int
+		err   error
+	}
+	results := make(chan closeResult, len(closers))
+	for i, closer := range closers {
+		go func(index int, closeFunc func() error) {
+			results <- closeResult{index: index, err: closeFunc()}
+		}(i, closer.close)
+	}
+
+	var failures []string
+	pending := make(map[int]bool, len(closers))
+	for i := range closers {
+		pending[i] = true
+	}
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.index)
+			if result.err != nil {
+				failures = append(failures, closers[result.index].name+": "+result.err.Error())
+			}
+		case <-ctx.Done():
+			for index := range pending {
+				failures = append(failures, closers[index].name+": "+ctx.Err().Error())
+			}
+			pending = nil
+		}
+	}
+	sort.Strings(failures)
+	return failures
+}
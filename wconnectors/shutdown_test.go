@@ -0,0 +1,69 @@
+package wconnectors
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	RegisterMockDb("shutdown_db")
+	db := Db("shutdown_db")
+	mock := DbMock("shutdown_db")
+	RegisterLocalCache("shutdown_cache", LocalCacheSettings{Size: 10, TTL: 60})
+	LocalCache("shutdown_cache").Set("key", "value")
+
+	writer, err := NewBulkWriter("shutdown_db", "ad_event", []string{"ad_id", "views"}, BulkWriterSettings{FlushInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Add(ctx, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Add(ctx, 2, 20); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectExec("INSERT INTO `ad_event`").WithArgs(1, 10, 2, 20).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the pending rows were written before the db was closed
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if reopened := Db("shutdown_db"); reopened == db {
+		t.Error("Db() after Shutdown() returned the closed pool")
+	}
+	if DbMock("shutdown_db") == mock {
+		t.Error("DbMock() after Shutdown() returned the previous mock")
+	}
+	if _, ok := LocalCache("shutdown_cache").Get("key"); ok {
+		t.Error("LocalCache() after Shutdown() kept the previous values")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	RegisterMockDb("shutdown_slow_db")
+	writer, err := NewBulkWriter("shutdown_slow_db", "ad_event_slow", []string{"ad_id"}, BulkWriterSettings{FlushInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a flush in progress holds the mutex, the close waits for it
+	writer.mutex.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = Shutdown(ctx)
+	writer.mutex.Unlock()
+
+	if err == nil || !strings.Contains(err.Error(), "bulk writer ad_event_slow: "+context.DeadlineExceeded.Error()) {
+		t.Errorf("Shutdown() = %v, want the bulk writer that did not close in time", err)
+	}
+	writer.Close(context.Background())
+}
@@ -11,3 +11,9 @@ var ContextKeyReferer = Key(2)
 
 // ContextKeyUserAgent is the context key for our middleware that adds the user agent
 var ContextKeyUserAgent = Key(3)
+
+// ContextKeyReadFromPrimary is the context key telling wconnectors.DbReader to return the primary, for read-after-write paths
+var ContextKeyReadFromPrimary = Key(4)
+
+// ContextKeyTx is the context key of the transaction of wconnectors.WithTx, the nested calls use savepoints
+var ContextKeyTx = Key(5)
@@ -0,0 +1,205 @@
+package wlog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AggregatorSettings is the struct that is used for configuring the aggregation of the copies of an error
+type AggregatorSettings struct {
+	Window          time.Duration // copies are counted and summarized once per window, default 1m
+	Levels          []int         // aggregated levels, default LevelCritical, LevelError and LevelWarning
+	MaxFingerprints int           // the entries of the fingerprints beyond it are sent as is, default 10000
+}
+
+// Aggregator sends the first occurrence of an error to the destination, then a summary per window
+// counting its copies instead of every copy, the errors are identified by their fingerprint
+type Aggregator struct {
+	destination ILogger
+	settings    AggregatorSettings
+	mutex       sync.Mutex
+	occurrences map[string]*occurrence
+	done        chan struct{}
+	stopped     chan struct{}
+	closeOnce   sync.Once
+}
+
+// occurrence holds the first entry of a fingerprint and its copies since the last summary
+type occurrence struct {
+	entry     Entry
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// NewAggregator will instantiate our logger on top of another destination
+// ex : wlog.SetLogger(wlog.NewAggregator(wlog.NewGraylog(ip, port)), "myapp", "mygroup")
+func NewAggregator(destination ILogger, settingsOpt ...AggregatorSettings) *Aggregator {
+	var settings AggregatorSettings
+	if len(settingsOpt) > 0 {
+		settings = settingsOpt[0]
+	}
+	if settings.Window <= 0 {
+		settings.Window = time.Minute
+	}
+	if settings.Levels == nil {
+		settings.Levels = []int{LevelCritical, LevelError, LevelWarning}
+	}
+	if settings.MaxFingerprints <= 0 {
+		settings.MaxFingerprints = 10000
+	}
+
+	aggregator := new(Aggregator)
+	aggregator.destination = destination
+	aggregator.settings = settings
+	aggregator.occurrences = make(map[string]*occurrence)
+	aggregator.done = make(chan struct{})
+	aggregator.stopped = make(chan struct{})
+	go aggregator.run()
+	return aggregator
+}
+
+// Critical is used for errors that cannot be recovered
+func (logger *Aggregator) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
+	WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// Error is used for errors that cannot be recovered but we can still live with them
+func (logger *Aggregator) Error(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelError, msg, r, 1))
+	WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// NotFound is used when a content or corresponding value was not found
+func (logger *Aggregator) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	WriteErrorResponse(w, http.StatusNotFound)
+	return nil
+}
+
+// Warning is used for errors that have been recovered
+func (logger *Aggregator) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
+	return nil
+}
+
+// Notice is mainly used internally for debugging to console
+func (logger *Aggregator) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
+	return nil
+}
+
+// Debug is mainly used internally for debugging to console
+func (logger *Aggregator) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	return nil
+}
+
+// Send hands the first occurrence of an error over to the destination and counts its copies
+func (logger *Aggregator) Send(entry *Entry) error {
+	if entry == nil {
+		return nil
+	}
+	if entry.Fingerprint == "" || !logger.isAggregated(entry.Level) {
+		return logger.destination.Send(entry)
+	}
+
+	logger.mutex.Lock()
+	seen, ok := logger.occurrences[entry.Fingerprint]
+	if ok {
+		seen.count++
+		seen.lastSeen = entry.Time
+		logger.mutex.Unlock()
+		return nil
+	}
+	if len(logger.occurrences) < logger.settings.MaxFingerprints {
+		logger.occurrences[entry.Fingerprint] = &occurrence{
+			entry:     *entry,
+			firstSeen: entry.Time,
+			lastSeen:  entry.Time,
+		}
+	}
+	logger.mutex.Unlock()
+	return logger.destination.Send(entry)
+}
+
+// Flush sends the summaries of the current window and flushes the destination
+func (logger *Aggregator) Flush(ctx context.Context) error {
+	logger.summarize()
+	return logger.destination.Flush(ctx)
+}
+
+// Close sends the summaries of the current window and closes the destination
+func (logger *Aggregator) Close() error {
+	logger.closeOnce.Do(func() {
+		close(logger.done)
+	})
+	<-logger.stopped
+	return logger.destination.Close()
+}
+
+func (logger *Aggregator) isAggregated(level int) bool {
+	for _, aggregatedLevel := range logger.settings.Levels {
+		if level == aggregatedLevel {
+			return true
+		}
+	}
+	return false
+}
+
+// run sends the summaries at the end of every window
+func (logger *Aggregator) run() {
+	defer close(logger.stopped)
+
+	ticker := time.NewTicker(logger.settings.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			logger.summarize()
+		case <-logger.done:
+			logger.summarize()
+			return
+		}
+	}
+}
+
+// summarize sends an entry per fingerprint that had copies since the last summary, the fingerprints
+// without copies are forgotten so that their next occurrence is sent again in full
+func (logger *Aggregator) summarize() {
+	var summaries []*Entry
+
+	logger.mutex.Lock()
+	for key, seen := range logger.occurrences {
+		if seen.count == 0 {
+			delete(logger.occurrences, key)
+			continue
+		}
+
+		summary := seen.entry
+		summary.Time = time.Now()
+		summary.Message = fmt.Sprintf("%s (repeated %d times)", seen.entry.Message, seen.count)
+		summary.Fields = make(map[string]interface{}, len(seen.entry.Fields)+3)
+		for key, value := range seen.entry.Fields {
+			summary.Fields[key] = value
+		}
+		summary.Fields["occurrences"] = seen.count
+		summary.Fields["first_seen"] = seen.firstSeen.Format(time.RFC3339)
+		summary.Fields["last_seen"] = seen.lastSeen.Format(time.RFC3339)
+		summaries = append(summaries, &summary)
+
+		seen.count = 0
+	}
+	logger.mutex.Unlock()
+
+	for _, summary := range summaries {
+		logger.destination.Send(summary)
+	}
+}
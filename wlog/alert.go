@@ -0,0 +1,249 @@
+package wlog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/webediads/adsgolib/wconfig"
+)
+
+// Alert is what the alert hooks receive for a critical or error entry
+type Alert struct {
+	Time        time.Time
+	App         string
+	AppGroup    string
+	Environment string // from wconfig.Config.GetEnvironment()
+	Level       int
+	Message     string
+	Caller      string // file:line
+	URL         string // empty outside of a request
+	Fingerprint string
+	Throttled   int // alerts of the same fingerprint that were not sent since the previous one
+}
+
+// AlertHook is implemented by the alert receivers, see Webhook and AlertFunc
+type AlertHook interface {
+	Alert(alert *Alert) error
+}
+
+// AlertFunc turns a function into an AlertHook
+type AlertFunc func(alert *Alert) error
+
+// Alert calls the function
+func (hook AlertFunc) Alert(alert *Alert) error {
+	return hook(alert)
+}
+
+// AlertSettings is the struct that is used for configuring when the alert hooks are called
+type AlertSettings struct {
+	Name      string        // name of the destination in Stats, default alerts, then alerts-2...
+	Levels    []int         // levels that trigger an alert, default LevelCritical and LevelError
+	Throttle  time.Duration // min time between two alerts of the same fingerprint, default 5m
+	QueueSize int           // alerts waiting to be sent, default 100, the alerts beyond it are lost
+}
+
+// Alerter sends the entries to a destination and calls the alert hooks for the critical and error ones,
+// the hooks are called asynchronously so that a slow webhook does not slow the requests down
+type Alerter struct {
+	destination ILogger
+	hooks       []AlertHook
+	settings    AlertSettings
+	mutex       sync.Mutex
+	lastAlerts  map[string]time.Time
+	throttled   map[string]int
+	queue       chan *Alert
+	closed      bool
+	pending     sync.WaitGroup
+	stopped     chan struct{}
+	closeOnce   sync.Once
+	metrics     *sinkMetrics
+}
+
+// maxThrottledFingerprints is the size of the throttling map above which the expired fingerprints are removed
+const maxThrottledFingerprints = 10000
+
+// NewAlerter will instantiate our logger on top of another destination
+// ex : wlog.NewAlerter(wlog.NewGraylog(ip, port), []wlog.AlertHook{wlog.NewWebhook(mattermostURL)})
+func NewAlerter(destination ILogger, hooks []AlertHook, settingsOpt ...AlertSettings) *Alerter {
+	var settings AlertSettings
+	if len(settingsOpt) > 0 {
+		settings = settingsOpt[0]
+	}
+	if settings.Levels == nil {
+		settings.Levels = []int{LevelCritical, LevelError}
+	}
+	if settings.Throttle <= 0 {
+		settings.Throttle = 5 * time.Minute
+	}
+	if settings.QueueSize <= 0 {
+		settings.QueueSize = 100
+	}
+
+	alerter := new(Alerter)
+	alerter.destination = destination
+	alerter.hooks = hooks
+	alerter.settings = settings
+	alerter.lastAlerts = make(map[string]time.Time)
+	alerter.throttled = make(map[string]int)
+	alerter.queue = make(chan *Alert, settings.QueueSize)
+	alerter.stopped = make(chan struct{})
+	alerter.metrics = metricsFor("alerts", settings.Name)
+	alerter.metrics.watchQueue(func() (int, int) {
+		return len(alerter.queue), cap(alerter.queue)
+	})
+	go alerter.run()
+	return alerter
+}
+
+// Critical is used for errors that cannot be recovered
+func (logger *Alerter) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
+	WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// Error is used for errors that cannot be recovered but we can still live with them
+func (logger *Alerter) Error(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelError, msg, r, 1))
+	WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// NotFound is used when a content or corresponding value was not found
+func (logger *Alerter) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	WriteErrorResponse(w, http.StatusNotFound)
+	return nil
+}
+
+// Warning is used for errors that have been recovered
+func (logger *Alerter) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
+	return nil
+}
+
+// Notice is mainly used internally for debugging to console
+func (logger *Alerter) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
+	return nil
+}
+
+// Debug is mainly used internally for debugging to console
+func (logger *Alerter) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	return nil
+}
+
+// Send hands the entry over to the destination and queues an alert if its level triggers one
+func (logger *Alerter) Send(entry *Entry) error {
+	if entry == nil {
+		return nil
+	}
+	if logger.triggers(entry.Level) {
+		logger.alert(entry)
+	}
+	return logger.destination.Send(entry)
+}
+
+// Flush waits for the queued alerts to be sent and flushes the destination
+func (logger *Alerter) Flush(ctx context.Context) error {
+	sent := make(chan struct{})
+	go func() {
+		logger.pending.Wait()
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-ctx.Done():
+		// the alerts keep being sent in the background
+		logger.metrics.failed(fmt.Errorf("%d alerts not sent before the flush deadline: %w", len(logger.queue), ctx.Err()))
+	}
+	return logger.destination.Flush(ctx)
+}
+
+// Close sends the queued alerts, Flush should be called first to bound the wait, and closes the destination
+func (logger *Alerter) Close() error {
+	logger.closeOnce.Do(func() {
+		logger.mutex.Lock()
+		logger.closed = true
+		close(logger.queue)
+		logger.mutex.Unlock()
+	})
+	<-logger.stopped
+	logger.metrics.unregister()
+	return logger.destination.Close()
+}
+
+func (logger *Alerter) triggers(level int) bool {
+	for _, alertLevel := range logger.settings.Levels {
+		if level == alertLevel {
+			return true
+		}
+	}
+	return false
+}
+
+// alert queues the alert of an entry unless its fingerprint already had one during the throttle window
+func (logger *Alerter) alert(entry *Entry) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logger.closed {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(logger.lastAlerts[entry.Fingerprint]) < logger.settings.Throttle {
+		logger.throttled[entry.Fingerprint]++
+		logger.metrics.throttled(entry.Level)
+		return
+	}
+	if len(logger.lastAlerts) >= maxThrottledFingerprints {
+		for fingerprint, lastAlert := range logger.lastAlerts {
+			if now.Sub(lastAlert) >= logger.settings.Throttle {
+				delete(logger.lastAlerts, fingerprint)
+				delete(logger.throttled, fingerprint)
+			}
+		}
+	}
+
+	alert := &Alert{
+		Time:        entry.Time,
+		App:         entry.App,
+		AppGroup:    entry.AppGroup,
+		Environment: wconfig.Config.GetEnvironment(),
+		Level:       entry.Level,
+		Message:     entry.Message,
+		Caller:      fmt.Sprintf("%s:%d", entry.File, entry.Line),
+		Fingerprint: entry.Fingerprint,
+		Throttled:   logger.throttled[entry.Fingerprint],
+	}
+	if entry.URL != "unknown" {
+		alert.URL = entry.URL
+	}
+
+	logger.pending.Add(1)
+	select {
+	case logger.queue <- alert:
+		logger.lastAlerts[entry.Fingerprint] = now
+		logger.throttled[entry.Fingerprint] = 0
+	default:
+		logger.pending.Done()
+		logger.metrics.dropped(entry.Level)
+	}
+}
+
+// run calls the hooks for every queued alert
+func (logger *Alerter) run() {
+	defer close(logger.stopped)
+
+	for alert := range logger.queue {
+		for _, hook := range logger.hooks {
+			logger.metrics.done(alert.Level, hook.Alert(alert))
+		}
+		logger.pending.Done()
+	}
+}
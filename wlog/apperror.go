@@ -0,0 +1,155 @@
+package wlog
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// AppError is an error returned by a handler, it knows the response to send and how to log it,
+// the log level is derived from the status unless it is set with WithLevel
+// ex : return wlog.NewAppError(http.StatusBadRequest, "invalid date", err).WithField("date", dateStr)
+type AppError struct {
+	Status   int                    // http status of the response, 500 if it is not a valid status
+	Message  string                 // written in the response, the default text of the status is used if empty
+	Cause    error                  // logged but never written in the response
+	Fields   map[string]interface{} // added to the entry
+	logLevel int                    // set with WithLevel, a field could not tell LevelCritical (0) from a level left unset
+	levelSet bool
+	stack    []uintptr
+}
+
+// NewAppError returns an error with the response to send, it is logged as an error for the 5xx statuses,
+// as debug for the 404 (like NotFound) and as a notice for the other statuses
+func NewAppError(status int, message string, cause error) *AppError {
+	return &AppError{
+		Status:  status,
+		Message: message,
+		Cause:   cause,
+		stack:   callers(1),
+	}
+}
+
+// statusLevel returns the log level of a status
+func statusLevel(status int) int {
+	if status < 100 || status >= http.StatusInternalServerError {
+		return LevelError
+	} else if status == http.StatusNotFound {
+		return LevelDebug
+	}
+	return LevelNotice
+}
+
+// NotFoundError returns a 404 error, logged as debug
+func NotFoundError(message string) *AppError {
+	appErr := NewAppError(http.StatusNotFound, message, nil)
+	appErr.stack = callers(1)
+	return appErr
+}
+
+// WithLevel changes the log level of the error, which is derived from the status otherwise
+func (e *AppError) WithLevel(level int) *AppError {
+	e.logLevel = level
+	e.levelSet = true
+	return e
+}
+
+// status returns the status of the response, a 500 unless Status is valid
+func (e *AppError) status() int {
+	if e.Status < 100 {
+		return http.StatusInternalServerError
+	}
+	return e.Status
+}
+
+// level returns the log level, the one of the status unless it was set with WithLevel
+func (e *AppError) level() int {
+	if e.levelSet {
+		return e.logLevel
+	}
+	return statusLevel(e.Status)
+}
+
+// WithField adds a field to the entry of the error
+func (e *AppError) WithField(key string, value interface{}) *AppError {
+	if e.Fields == nil {
+		e.Fields = make(map[string]interface{})
+	}
+	e.Fields[key] = value
+	return e
+}
+
+func (e *AppError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = strings.ToLower(http.StatusText(e.status()))
+	}
+	if e.Cause == nil {
+		return message
+	}
+	return message + ": " + e.Cause.Error()
+}
+
+// Unwrap returns the cause of the error
+func (e *AppError) Unwrap() error {
+	return e.Cause
+}
+
+// StackTrace returns the stack where the error was created
+func (e *AppError) StackTrace() []uintptr {
+	return e.stack
+}
+
+// WriteResponse writes the status of the error (500 if Status is not a valid status) and its public message,
+// as json when the client accepts it, the default responses of WriteErrorResponse are used when there is no public message.
+// The error is not logged, ServeHTTP of HandlerFunc does it
+// ex : appErr.WriteResponse(w, r)
+func (e *AppError) WriteResponse(w http.ResponseWriter, r *http.Request) {
+	status := e.status()
+	if r != nil && strings.Contains(r.Header.Get("Accept"), "application/json") {
+		message := e.Message
+		if message == "" {
+			message = strings.ToLower(http.StatusText(status))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": status,
+			"error":  message,
+		})
+		return
+	}
+
+	if e.Message == "" {
+		WriteErrorResponse(w, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(e.Message))
+}
+
+// HandlerFunc is a handler returning its error instead of logging it and writing the response itself
+// ex : router.Handle("/article/{id}", wlog.HandlerFunc(articleHandler))
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// ServeHTTP calls the handler, logs the error it returns at its level and writes the response,
+// the errors that are not an *AppError are logged as errors and get a 500
+func (handler HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := handler(w, r)
+	if err == nil {
+		return
+	}
+
+	var appErr *AppError
+	if !errors.As(err, &appErr) {
+		appErr = &AppError{Status: http.StatusInternalServerError, Cause: err}
+	}
+	// the caller would be ServeHTTP, where the error was created is more helpful
+	atOrigin := func(options *logOptions) {
+		options.atOrigin = true
+	}
+	LogError(appErr.level(), err, Request(r), Fields(appErr.Fields), atOrigin)
+	appErr.WriteResponse(w, r)
+}
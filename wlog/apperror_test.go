@@ -0,0 +1,55 @@
+package wlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAppErrorLevel(t *testing.T) {
+	tests := []struct {
+		name   string
+		appErr *AppError
+		want   int
+	}{
+		{"literal 400", &AppError{Status: http.StatusBadRequest}, LevelNotice},
+		{"literal 404", &AppError{Status: http.StatusNotFound}, LevelDebug},
+		{"literal 503", &AppError{Status: http.StatusServiceUnavailable}, LevelError},
+		{"literal without status", &AppError{}, LevelError},
+		{"literal critical", (&AppError{Status: http.StatusInternalServerError}).WithLevel(LevelCritical), LevelCritical},
+		{"literal level", (&AppError{Status: http.StatusBadRequest}).WithLevel(LevelWarning), LevelWarning},
+		{"constructor", NewAppError(http.StatusConflict, "", nil), LevelNotice},
+		{"constructor 500", NewAppError(http.StatusInternalServerError, "", nil), LevelError},
+		{"critical", NewAppError(http.StatusBadGateway, "", nil).WithLevel(LevelCritical), LevelCritical},
+		{"not found", NotFoundError("no such ad"), LevelDebug},
+	}
+	for _, test := range tests {
+		if got := test.appErr.level(); got != test.want {
+			t.Errorf("%s: level() = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAppErrorWriteResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		appErr *AppError
+		accept string
+		want   int
+		body   string
+	}{
+		{"message", &AppError{Status: http.StatusBadRequest, Message: "invalid date"}, "", http.StatusBadRequest, "invalid date"},
+		{"json", &AppError{Status: http.StatusConflict}, "application/json", http.StatusConflict, "{\"error\":\"conflict\",\"status\":409}\n"},
+		{"no status", &AppError{Message: "oops"}, "", http.StatusInternalServerError, "oops"},
+		{"no status json", &AppError{}, "application/json", http.StatusInternalServerError, "{\"error\":\"internal server error\",\"status\":500}\n"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept", test.accept)
+		recorder := httptest.NewRecorder()
+		test.appErr.WriteResponse(recorder, request)
+		if recorder.Code != test.want || recorder.Body.String() != test.body {
+			t.Errorf("%s: WriteResponse() = %d %q, want %d %q", test.name, recorder.Code, recorder.Body.String(), test.want, test.body)
+		}
+	}
+}
@@ -1,57 +1,231 @@
 package wlog
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// Console is a stupid logger that outputs to stdout
+// ConsoleFormatHuman is a colourised format for local development
+const ConsoleFormatHuman = "human"
+
+// ConsoleFormatJSON writes one json object per line, for the container log collectors
+const ConsoleFormatJSON = "json"
+
+// ConsoleSettings is the struct that is used for configuring the console destination
+type ConsoleSettings struct {
+	Name    string    // name of the destination in Stats, default console, then console-2...
+	Format  string    // ConsoleFormatHuman (default) or ConsoleFormatJSON
+	Writer  io.Writer // default os.Stdout
+	NoColor bool      // disables the colours of the human format
+}
+
+// Console is a logger that outputs to stdout or any writer
 type Console struct {
+	settings ConsoleSettings
+	metrics  *sinkMetrics
+}
+
+// consoleMutex keeps the lines of concurrent entries from being mixed
+var consoleMutex sync.Mutex
+
+var consoleColors = map[int]string{
+	LevelCritical: "\033[1;31m",
+	LevelError:    "\033[31m",
+	LevelWarning:  "\033[33m",
+	LevelNotice:   "\033[36m",
+	LevelDebug:    "\033[90m",
 }
 
+const consoleColorReset = "\033[0m"
+
 // NewConsole will instantiate our logger
-func NewConsole() *Console {
+// ex : wlog.NewConsole(wlog.ConsoleSettings{Format: wlog.ConsoleFormatJSON})
+func NewConsole(settingsOpt ...ConsoleSettings) *Console {
 	console := new(Console)
+	if len(settingsOpt) > 0 {
+		console.settings = settingsOpt[0]
+	}
+	console.metrics = metricsFor("console", console.settings.Name)
 	return console
 }
 
 // Critical is used for errors that cannot be recovered
 func (logger Console) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(msg)
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
 	return nil
 }
 
 // Error is used for errors that cannot be recovered but we can still live with them
 func (logger Console) Error(msg string, w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(msg)
+	logger.Send(NewEntry(LevelError, msg, r, 1))
 	return nil
 }
 
 // NotFound is used when a content or corresponding value was not found
 func (logger Console) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(msg)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	return nil
 }
 
 // Warning is used for errors that have been recovered
 func (logger Console) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(msg)
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
 	return nil
 }
 
 // Notice is mainly used internally for debugging to console
 func (logger Console) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(msg)
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
 	return nil
 }
 
 // Debug is mainly used internally for debugging to console
 func (logger Console) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(msg)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	return nil
 }
 
-// sendToGraylog formats and sends a message to graylog along with the filename, line number, etc
-func (logger Console) sendToDestination(msg string, r *http.Request) {
-	fmt.Println("not called")
+// Send writes an entry in the configured format
+func (logger Console) Send(entry *Entry) error {
+	if entry == nil {
+		return nil
+	}
+	metrics := logger.metrics
+	if metrics == nil {
+		// a Console{} that was not made by NewConsole
+		metrics = metricsFor("console", "console")
+	}
+	if !metrics.accept(entry) {
+		return nil
+	}
+
+	var line []byte
+	if logger.settings.Format == ConsoleFormatJSON {
+		var err error
+		line, err = logger.formatJSON(entry)
+		if err != nil {
+			return metrics.done(entry.Level, err)
+		}
+	} else {
+		line = logger.formatHuman(entry)
+	}
+
+	writer := logger.settings.Writer
+	if writer == nil {
+		writer = os.Stdout
+	}
+	consoleMutex.Lock()
+	_, err := writer.Write(line)
+	consoleMutex.Unlock()
+	return metrics.done(entry.Level, err)
+}
+
+// Flush syncs the writer when it is a file (os.Stdout included), the entries are written synchronously
+func (logger Console) Flush(ctx context.Context) error {
+	writer := logger.settings.Writer
+	if writer == nil {
+		writer = os.Stdout
+	}
+	if syncer, ok := writer.(interface{ Sync() error }); ok {
+		consoleMutex.Lock()
+		defer consoleMutex.Unlock()
+		// stdout can't be synced when it is a pipe or a terminal, it doesn't lose anything either
+		if err := syncer.Sync(); err != nil && writer != os.Stdout && writer != os.Stderr {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing, the writer belongs to the caller
+func (logger Console) Close() error {
+	logger.metrics.unregister()
+	return nil
+}
+
+// formatHuman formats an entry on one line, followed by its error chain
+// ex : 2006-01-02 15:04:05.000 ERROR myapp handlers/home.go:42 message url=/home ip_address=1.2.3.4 key=value
+func (logger Console) formatHuman(entry *Entry) []byte {
+	var line strings.Builder
+
+	level := strings.ToUpper(LevelName(entry.Level))
+	if color, ok := consoleColors[entry.Level]; ok && !logger.settings.NoColor {
+		level = color + level + consoleColorReset
+	}
+
+	fmt.Fprintf(&line, "%s %s %s %s:%d %s",
+		entry.Time.Format("2006-01-02 15:04:05.000"),
+		level,
+		entry.App,
+		filepath.Join(filepath.Base(filepath.Dir(entry.File)), filepath.Base(entry.File)),
+		entry.Line,
+		entry.Message,
+	)
+
+	requestFields := [][2]string{
+		{"url", entry.URL},
+		{"ip_address", entry.IPAddress},
+		{"url_referer", entry.URLReferer},
+		{"user_agent", entry.UserAgent},
+	}
+	for _, requestField := range requestFields {
+		if requestField[1] != "" && requestField[1] != "unknown" {
+			fmt.Fprintf(&line, " %s=%q", requestField[0], requestField[1])
+		}
+	}
+
+	keys := make([]string, 0, len(entry.Fields))
+	for key := range entry.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&line, " %s=%v", key, entry.Fields[key])
+	}
+	line.WriteString("\n")
+
+	for i, link := range entry.ErrorChain {
+		if i > 0 {
+			line.WriteString("\tcaused by: " + link + "\n")
+		}
+	}
+
+	return []byte(line.String())
+}
+
+// formatJSON formats an entry as a json line, the fields are added next to the standard keys
+func (logger Console) formatJSON(entry *Entry) ([]byte, error) {
+	line := make(map[string]interface{}, len(entry.Fields)+13)
+	for key, value := range entry.Fields {
+		line[key] = value
+	}
+	line["time"] = entry.Time.Format(time.RFC3339Nano)
+	line["level"] = LevelName(entry.Level)
+	line["app"] = entry.App
+	line["app_group"] = entry.AppGroup
+	line["caller"] = fmt.Sprintf("%s:%d", entry.File, entry.Line)
+	line["message"] = entry.Message
+	line["ip_address"] = entry.IPAddress
+	line["url"] = entry.URL
+	line["url_referer"] = entry.URLReferer
+	line["user_agent"] = entry.UserAgent
+	line["fingerprint"] = entry.Fingerprint
+	if len(entry.ErrorChain) > 0 {
+		line["error_chain"] = entry.ErrorChain
+	}
+
+	lineJSON, err := json.Marshal(line)
+	if err != nil {
+		return nil, err
+	}
+	return append(lineJSON, '\n'), nil
 }
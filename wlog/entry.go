@@ -0,0 +1,149 @@
+package wlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/webediads/adsgolib/wcontext"
+)
+
+// Entry is a log message along with the caller and request details our destinations need to format it
+type Entry struct {
+	Time        time.Time              `json:"time"`
+	App         string                 `json:"app"`
+	AppGroup    string                 `json:"app_group"`
+	Level       int                    `json:"level"`
+	Message     string                 `json:"message"`
+	FullMessage string                 `json:"full_message"`
+	ErrorChain  []string               `json:"error_chain,omitempty"`
+	Fingerprint string                 `json:"fingerprint"`
+	File        string                 `json:"file"`
+	Line        int                    `json:"line"`
+	IPAddress   string                 `json:"ip_address"`
+	URL         string                 `json:"url"`
+	URLReferer  string                 `json:"url_referer"`
+	UserAgent   string                 `json:"user_agent"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+}
+
+// maxStackDepth is the number of frames we keep in the stacks
+const maxStackDepth = 64
+
+// NewEntry builds the redacted entry of a message, skip is the number of frames between the caller
+// of NewEntry and the code that is reported as the origin of the message (1 for the level methods)
+func NewEntry(level int, msg string, r *http.Request, skip int) *Entry {
+	return redactEntry(buildEntry(level, msg, r, skip+1))
+}
+
+// buildEntry is NewEntry without the redaction, for the callers that complete the entry before sending it
+func buildEntry(level int, msg string, r *http.Request, skip int) *Entry {
+	// skip buildEntry
+	stack := callers(skip + 1)
+	if len(stack) == 0 {
+		return nil
+	}
+	frame, _ := runtime.CallersFrames(stack).Next()
+
+	// default values
+	logIP := "unknown"
+	logReferer := "unknown"
+	logUserAgent := "unknown"
+	logURL := "unknown"
+
+	if r != nil {
+		logIP, logReferer, logUserAgent = contextDetails(r.Context())
+		logURL = r.URL.RequestURI()
+	}
+
+	return &Entry{
+		Time:        time.Now(),
+		App:         Logger.appName,
+		AppGroup:    Logger.appGroupName,
+		Level:       level,
+		Message:     msg,
+		FullMessage: formatStack(stack),
+		Fingerprint: fingerprint(msg, stack),
+		File:        frame.File,
+		Line:        frame.Line,
+		IPAddress:   logIP,
+		URL:         logURL,
+		URLReferer:  logReferer,
+		UserAgent:   logUserAgent,
+	}
+}
+
+// contextDetails returns the request details our middlewares store in the context
+func contextDetails(ctx context.Context) (ip string, referer string, userAgent string) {
+	ip, _ = ctx.Value(wcontext.ContextKeyRequestIP).(string)
+	referer, _ = ctx.Value(wcontext.ContextKeyReferer).(string)
+	userAgent, _ = ctx.Value(wcontext.ContextKeyUserAgent).(string)
+	return ip, referer, userAgent
+}
+
+// callers returns the program counters of the stack, skip is the number of frames to skip,
+// 0 being the caller of callers
+func callers(skip int) []uintptr {
+	pcs := make([]uintptr, maxStackDepth)
+	// skip runtime.Callers and callers
+	n := runtime.Callers(skip+2, pcs)
+	return pcs[:n]
+}
+
+// formatStack formats the program counters the same way as debug.Stack does
+func formatStack(stack []uintptr) string {
+	if len(stack) == 0 {
+		return ""
+	}
+	var formatted strings.Builder
+	frames := runtime.CallersFrames(stack)
+	for depth := 0; depth < maxStackDepth; depth++ {
+		frame, more := frames.Next()
+		fmt.Fprintf(&formatted, "%s()\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return formatted.String()
+}
+
+// gelf returns the GELF payload of the entry as sent to Graylog
+func (entry *Entry) gelf() errorGelf {
+	return errorGelf{
+		App:          entry.App,
+		AppGroup:     entry.AppGroup,
+		ShortMessage: entry.Message,
+		FullMessage:  entry.FullMessage,
+		IPAddress:    entry.IPAddress,
+		Level:        entry.Level,
+		Line:         entry.Line,
+		Source:       entry.File,
+		URL:          entry.URL,
+		URLReferer:   entry.URLReferer,
+		UserAgent:    entry.UserAgent,
+		Fingerprint:  entry.Fingerprint,
+		Fields:       entry.Fields,
+	}
+}
+
+// MarshalJSON adds the fields of the entry to the GELF payload, the standard ones take precedence
+func (payload errorGelf) MarshalJSON() ([]byte, error) {
+	type plainGelf errorGelf
+	payloadJSON, err := json.Marshal(plainGelf(payload))
+	if err != nil || len(payload.Fields) == 0 {
+		return payloadJSON, err
+	}
+
+	merged := make(map[string]interface{}, len(payload.Fields)+12)
+	for key, value := range payload.Fields {
+		merged[key] = value
+	}
+	if err := json.Unmarshal(payloadJSON, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,200 @@
+package wlog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// Option customizes the entries sent with Log and LogError
+type Option func(*logOptions)
+
+type logOptions struct {
+	skip   int
+	w      http.ResponseWriter
+	r      *http.Request
+	fields map[string]interface{}
+	// reports where the error was created instead of where it was logged, for the adapters
+	atOrigin bool
+}
+
+// Skip reports the caller that is frames above the one calling Log or LogError, for logging helpers
+func Skip(frames int) Option {
+	return func(options *logOptions) {
+		options.skip += frames
+	}
+}
+
+// Request adds the request details (ip, url, referer, user agent) to the entry
+func Request(r *http.Request) Option {
+	return func(options *logOptions) {
+		options.r = r
+	}
+}
+
+// Response writes the same error response as the level methods for the critical and error levels
+func Response(w http.ResponseWriter) Option {
+	return func(options *logOptions) {
+		options.w = w
+	}
+}
+
+// Fields adds structured fields to the entry
+func Fields(fields map[string]interface{}) Option {
+	return func(options *logOptions) {
+		for key, value := range fields {
+			Field(key, value)(options)
+		}
+	}
+}
+
+// Field adds a structured field to the entry
+func Field(key string, value interface{}) Option {
+	return func(options *logOptions) {
+		if options.fields == nil {
+			options.fields = make(map[string]interface{})
+		}
+		options.fields[key] = value
+	}
+}
+
+// Log sends a message to the destination set with SetLogger
+func Log(level int, msg string, opts ...Option) error {
+	return logWithOptions(level, msg, nil, opts)
+}
+
+// LogError sends an error to the destination set with SetLogger, along with its whole chain
+// of wrapped errors and the stack where it was created if it was created by this package
+func LogError(level int, err error, opts ...Option) error {
+	if err == nil {
+		return nil
+	}
+	return logWithOptions(level, err.Error(), err, opts)
+}
+
+func logWithOptions(level int, msg string, err error, opts []Option) error {
+	if Logger.destination == nil {
+		return errNoDestination
+	}
+
+	options := logOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	// skip logWithOptions and Log/LogError
+	entry := buildEntry(level, msg, options.r, options.skip+2)
+	if entry == nil {
+		return nil
+	}
+	entry.Fields = options.fields
+	if err != nil {
+		entry.ErrorChain = errorChain(err)
+		// the copies of an error are logged from several places, where it was created is more stable
+		if stack := originStack(err); len(stack) > 0 {
+			entry.Fingerprint = fingerprint(msg, stack)
+			if options.atOrigin {
+				frame, _ := runtime.CallersFrames(stack).Next()
+				entry.File = frame.File
+				entry.Line = frame.Line
+			}
+		}
+		entry.FullMessage = formatError(err, entry.FullMessage)
+	}
+	sendErr := sendToLogger(entry)
+
+	if level <= LevelError {
+		WriteErrorResponse(options.w, http.StatusInternalServerError)
+	}
+	return sendErr
+}
+
+// StackTracer is implemented by the errors that know the stack where they were created
+type StackTracer interface {
+	StackTrace() []uintptr
+}
+
+// stackError is an error recording the stack where it was created
+type stackError struct {
+	err   error
+	stack []uintptr
+}
+
+// NewError returns an error recording the stack where it was created
+func NewError(msg string) error {
+	return &stackError{err: errors.New(msg), stack: callers(1)}
+}
+
+// Errorf formats an error with fmt.Errorf (%w included) and records the stack where it was created
+func Errorf(format string, args ...interface{}) error {
+	return &stackError{err: fmt.Errorf(format, args...), stack: callers(1)}
+}
+
+// Wrap adds a message to an error and records the stack where it was wrapped, nil stays nil
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return &stackError{err: fmt.Errorf("%s: %w", msg, err), stack: callers(1)}
+}
+
+func (e *stackError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap skips the error we are built on, it has the same message
+func (e *stackError) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
+
+// StackTrace returns the stack where the error was created
+func (e *stackError) StackTrace() []uintptr {
+	return e.stack
+}
+
+// errorChain lists the errors wrapped by err, err included
+func errorChain(err error) []string {
+	var chain []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		link := err
+		if wrapper, ok := err.(*stackError); ok {
+			link = wrapper.err
+		}
+		chain = append(chain, fmt.Sprintf("%T: %s", link, link.Error()))
+	}
+	return chain
+}
+
+// originStack returns the stack of the deepest error of the chain that knows where it was created
+func originStack(err error) []uintptr {
+	var stack []uintptr
+	for ; err != nil; err = errors.Unwrap(err) {
+		if tracer, ok := err.(StackTracer); ok && len(tracer.StackTrace()) > 0 {
+			stack = tracer.StackTrace()
+		}
+	}
+	return stack
+}
+
+// formatError builds the full message of an error: its chain and the stack where it was created,
+// or loggingStack when the error does not know where it was created
+func formatError(err error, loggingStack string) string {
+	var formatted strings.Builder
+	for i, link := range errorChain(err) {
+		if i > 0 {
+			formatted.WriteString("caused by: ")
+		}
+		formatted.WriteString(link)
+		formatted.WriteString("\n")
+	}
+	if stack := originStack(err); len(stack) > 0 {
+		formatted.WriteString("\ncreated at:\n")
+		formatted.WriteString(formatStack(stack))
+	} else {
+		formatted.WriteString("\nlogged at:\n")
+		formatted.WriteString(loggingStack)
+	}
+	return formatted.String()
+}
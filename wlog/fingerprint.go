@@ -0,0 +1,54 @@
+package wlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// fingerprintFrames is the number of frames of the stack that are part of the fingerprint
+const fingerprintFrames = 3
+
+// fingerprintPatterns replace the variable parts of the messages, the order matters:
+// an uuid or a quoted string would otherwise be eaten by the number pattern
+var fingerprintPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<str>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]{8,}\b`), "<hex>"},
+	{regexp.MustCompile(`\d+(?:\.\d+)?`), "<n>"},
+}
+
+// normalizeMessage removes the ids, numbers and quoted values of a message so that
+// the copies of an error share the same message
+// ex : `user 42 not found in "users"` becomes `user <n> not found in <str>`
+func normalizeMessage(msg string) string {
+	for _, fingerprintPattern := range fingerprintPatterns {
+		msg = fingerprintPattern.pattern.ReplaceAllString(msg, fingerprintPattern.replacement)
+	}
+	return msg
+}
+
+// fingerprint identifies the copies of an error: the normalized message and the functions of the top
+// frames of the stack, the lines are left out so that the fingerprint survives the deployments
+func fingerprint(msg string, stack []uintptr) string {
+	var key strings.Builder
+	key.WriteString(normalizeMessage(msg))
+	if len(stack) > 0 {
+		frames := runtime.CallersFrames(stack)
+		for depth := 0; depth < fingerprintFrames; depth++ {
+			frame, more := frames.Next()
+			key.WriteString("\n")
+			key.WriteString(frame.Function)
+			if !more {
+				break
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(key.String()))
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,68 @@
+package wlog
+
+import (
+	"testing"
+)
+
+func TestNormalizeMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{`user 42 not found in "users"`, `user <n> not found in <str>`},
+		{"user 42 not found", "user <n> not found"},
+		{"order 3f2b9c1e-8d4a-4b6e-9f10-2a3b4c5d6e7f failed", "order <uuid> failed"},
+		{"pointer 0x1f2e3d4c is nil", "pointer <hex> is nil"},
+		{"hash deadbeefcafe mismatch", "hash <hex> mismatch"},
+		{"took 12.5ms", "took <n>ms"},
+		{"key 'a:1' expired", "key <str> expired"},
+		{"database is down", "database is down"},
+	}
+	for _, test := range tests {
+		if got := normalizeMessage(test.msg); got != test.want {
+			t.Errorf("normalizeMessage(%q) = %q, want %q", test.msg, got, test.want)
+		}
+	}
+}
+
+// fingerprintAt returns the fingerprint of a message logged from here
+func fingerprintAt(msg string) string {
+	return fingerprint(msg, callers(0))
+}
+
+// fingerprintsOnTwoLines returns the fingerprints of a message logged from two lines of the same function
+func fingerprintsOnTwoLines(msg string) (string, string) {
+	first := fingerprint(msg, callers(0))
+	second := fingerprint(msg, callers(0))
+	return first, second
+}
+
+// otherFingerprintAt returns the fingerprint of a message logged from another function
+func otherFingerprintAt(msg string) string {
+	return fingerprint(msg, callers(0))
+}
+
+func TestFingerprint(t *testing.T) {
+	same := fingerprintAt("user 42 not found")
+	tests := []struct {
+		name        string
+		fingerprint string
+		wantSame    bool
+	}{
+		{"other values", fingerprintAt("user 43 not found"), true},
+		{"other message", fingerprintAt("user not allowed"), false},
+		{"other function", otherFingerprintAt("user 42 not found"), false},
+		{"without stack", fingerprint("user 42 not found", nil), false},
+	}
+	for _, test := range tests {
+		if got := test.fingerprint == same; got != test.wantSame {
+			t.Errorf("%s: fingerprint %s, same as %s: %v, want %v", test.name, test.fingerprint, same, got, test.wantSame)
+		}
+	}
+	if first, second := fingerprintsOnTwoLines("user 42 not found"); first != second {
+		t.Errorf("the lines change the fingerprint: %s and %s", first, second)
+	}
+	if len(same) != 16 {
+		t.Errorf("fingerprint %q has %d characters, want 16", same, len(same))
+	}
+}
@@ -0,0 +1,137 @@
+package gelftest_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/webediads/adsgolib/wcontext"
+	"github.com/webediads/adsgolib/wlog"
+	"github.com/webediads/adsgolib/wlog/gelftest"
+)
+
+// newEntry returns an entry of a request along with fields
+func newEntry(level int, msg string, fields map[string]interface{}) *wlog.Entry {
+	r := httptest.NewRequest(http.MethodGet, "/article/12?page=2", nil)
+	ctx := context.WithValue(r.Context(), wcontext.ContextKeyRequestIP, "10.0.0.1")
+	ctx = context.WithValue(ctx, wcontext.ContextKeyReferer, "https://example.com/")
+	ctx = context.WithValue(ctx, wcontext.ContextKeyUserAgent, "gelftest")
+	entry := wlog.NewEntry(level, msg, r.WithContext(ctx), 1)
+	entry.Fields = fields
+	return entry
+}
+
+// randomString returns a string that does not compress, to get chunked udp messages
+func randomString(t *testing.T, size int) string {
+	random := make([]byte, size/2)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(random)
+}
+
+func TestGraylog(t *testing.T) {
+	receiver, err := gelftest.NewUDPReceiver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	wlog.SetLogger(wlog.NewConsole(), "myapp", "mygroup")
+	graylog := wlog.NewGraylog(receiver.Host(), receiver.Port())
+
+	tests := []struct {
+		name   string
+		level  int
+		fields map[string]interface{}
+	}{
+		{"compressed", wlog.LevelError, map[string]interface{}{"article": "12"}},
+		{"chunked", wlog.LevelWarning, map[string]interface{}{"article": "12", "payload": randomString(t, 8000)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver.Reset()
+			entry := newEntry(test.level, "article not saved", test.fields)
+			if err := graylog.Send(entry); err != nil {
+				t.Fatal(err)
+			}
+
+			message := receiver.WaitForMessages(t, 1)[0]
+			if errs := receiver.Errors(); len(errs) > 0 {
+				t.Fatalf("receiver errors: %v", errs)
+			}
+			if message.ShortMessage != "article not saved" || message.Level != test.level || message.FullMessage != entry.FullMessage {
+				t.Errorf("message = %q level %d, want %q level %d", message.ShortMessage, message.Level, "article not saved", test.level)
+			}
+			expected := map[string]string{
+				"app":         "myapp",
+				"app_group":   "mygroup",
+				"ip_address":  "10.0.0.1",
+				"url":         "/article/12?page=2",
+				"url_referer": "https://example.com/",
+				"user_agent":  "gelftest",
+				"source":      entry.File,
+				"line":        strconv.Itoa(entry.Line),
+				"fingerprint": entry.Fingerprint,
+			}
+			for key, value := range test.fields {
+				expected[key] = value.(string)
+			}
+			for key, value := range expected {
+				if got := message.String(key); got != value {
+					t.Errorf("%s = %q, want %q", key, got, value)
+				}
+			}
+			if entry.Fingerprint == "" {
+				t.Error("the entry has no fingerprint")
+			}
+		})
+	}
+}
+
+func TestProxyGelf(t *testing.T) {
+	receiver := gelftest.NewHTTPReceiver()
+	defer receiver.Close()
+	wlog.SetLogger(wlog.NewConsole(), "myapp", "mygroup")
+	proxyGelf := wlog.NewProxyGelf(receiver.URL())
+
+	// the fields of the entries are not sent to the proxy
+	entry := newEntry(wlog.LevelError, "article not saved", map[string]interface{}{"article": "12"})
+	if err := proxyGelf.Send(entry); err != nil {
+		t.Fatal(err)
+	}
+	message := receiver.WaitForMessages(t, 1)[0]
+	if message.ShortMessage != "article not saved" || message.Level != wlog.LevelError {
+		t.Errorf("message = %q level %d, want %q level %d", message.ShortMessage, message.Level, "article not saved", wlog.LevelError)
+	}
+	expected := map[string]string{
+		"app":         "myapp",
+		"app_group":   "mygroup",
+		"ip_address":  "10.0.0.1",
+		"url":         "/article/12?page=2",
+		"url_referer": "https://example.com/",
+		"user_agent":  "gelftest",
+		"file":        entry.File,
+		"line":        strconv.Itoa(entry.Line),
+		"level":       strconv.Itoa(wlog.LevelError),
+		"fingerprint": entry.Fingerprint,
+	}
+	for key, value := range expected {
+		if got := message.String(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if _, ok := message.Fields["article"]; ok {
+		t.Error("the fields of the entry were sent to the proxy")
+	}
+
+	receiver.Reset()
+	receiver.RespondWith(http.StatusBadGateway)
+	if err := proxyGelf.Send(newEntry(wlog.LevelError, "article not saved", nil)); err == nil {
+		t.Error("Send() = nil, want the error of the proxy")
+	}
+	receiver.WaitForMessages(t, 1)
+}
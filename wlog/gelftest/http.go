@@ -0,0 +1,56 @@
+package gelftest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+)
+
+// HTTPReceiver is a GELF http receiver, it also accepts the payloads of the gelf proxy used by ProxyGelf
+type HTTPReceiver struct {
+	*Receiver
+	server *httptest.Server
+	status int32
+}
+
+// NewHTTPReceiver starts a GELF http receiver on a random port of the loopback interface, every path is accepted
+// and the compressed bodies are inflated, it responds 202 like Graylog
+// ex : receiver := gelftest.NewHTTPReceiver(); defer receiver.Close(); wlog.NewProxyGelf(receiver.URL())
+func NewHTTPReceiver() *HTTPReceiver {
+	receiver := &HTTPReceiver{Receiver: newReceiver("http"), status: http.StatusAccepted}
+	receiver.server = httptest.NewServer(http.HandlerFunc(receiver.serveHTTP))
+	receiver.addr = strings.TrimPrefix(receiver.server.URL, "http://")
+	receiver.closeFunc = func() error {
+		receiver.server.Close()
+		return nil
+	}
+	return receiver
+}
+
+// URL returns the url of the GELF endpoint
+func (receiver *HTTPReceiver) URL() string {
+	return receiver.server.URL + "/gelf"
+}
+
+// RespondWith changes the status of the next responses, to test how the errors are handled,
+// the messages are still recorded
+func (receiver *HTTPReceiver) RespondWith(status int) {
+	atomic.StoreInt32(&receiver.status, int32(status))
+}
+
+func (receiver *HTTPReceiver) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		receiver.fail(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	receiver.receive(body)
+	w.WriteHeader(int(atomic.LoadInt32(&receiver.status)))
+}
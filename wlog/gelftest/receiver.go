@@ -0,0 +1,193 @@
+// Package gelftest provides in-process GELF receivers (udp, tcp and http) listening on random ports,
+// so that tests can check what the Graylog and ProxyGelf destinations send. RabbitMqGelf publishes over amqp
+// and needs a broker, wlog tests the payload it publishes, which is the one of Graylog
+package gelftest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Timeout is how long WaitForMessages waits for the messages
+var Timeout = 5 * time.Second
+
+// Message is a decoded GELF message
+type Message struct {
+	Transport    string                 // udp, tcp or http
+	ShortMessage string                 // short_message, or message for the gelf proxy payloads
+	FullMessage  string                 // full_message
+	Level        int                    // level, sent as a number or as a string
+	Fields       map[string]interface{} // every key of the payload, the standard ones included
+}
+
+// String returns a field as a string, "" if it is missing
+func (message Message) String(key string) string {
+	value, ok := message.Fields[key]
+	if !ok || value == nil {
+		return ""
+	}
+	if str, ok := value.(string); ok {
+		return str
+	}
+	return fmt.Sprint(value)
+}
+
+// Receiver holds the messages received by one of the receivers, it is safe for concurrent use
+type Receiver struct {
+	transport string
+	addr      string
+	mutex     sync.Mutex
+	messages  []Message
+	errs      []error
+	received  chan struct{}
+	closeFunc func() error
+}
+
+func newReceiver(transport string) *Receiver {
+	return &Receiver{transport: transport, received: make(chan struct{}, 1)}
+}
+
+// Addr returns the host:port the receiver listens on
+func (receiver *Receiver) Addr() string {
+	return receiver.addr
+}
+
+// Host returns the host the receiver listens on, ex: wlog.NewGraylog(receiver.Host(), receiver.Port())
+func (receiver *Receiver) Host() string {
+	return receiver.addr[:strings.LastIndex(receiver.addr, ":")]
+}
+
+// Port returns the port the receiver listens on
+func (receiver *Receiver) Port() string {
+	return receiver.addr[strings.LastIndex(receiver.addr, ":")+1:]
+}
+
+// Close stops the receiver
+func (receiver *Receiver) Close() error {
+	return receiver.closeFunc()
+}
+
+// Messages returns the received messages, oldest first
+func (receiver *Receiver) Messages() []Message {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return append([]Message(nil), receiver.messages...)
+}
+
+// Errors returns the payloads that could not be decoded or reassembled
+func (receiver *Receiver) Errors() []error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return append([]error(nil), receiver.errs...)
+}
+
+// Reset forgets the received messages and errors
+func (receiver *Receiver) Reset() {
+	receiver.mutex.Lock()
+	receiver.messages = nil
+	receiver.errs = nil
+	receiver.mutex.Unlock()
+}
+
+// WaitForMessages waits for count messages to be received and returns them, the test fails after Timeout
+func (receiver *Receiver) WaitForMessages(t testing.TB, count int) []Message {
+	t.Helper()
+	deadline := time.NewTimer(Timeout)
+	defer deadline.Stop()
+	for {
+		messages := receiver.Messages()
+		if len(messages) >= count {
+			return messages
+		}
+		select {
+		case <-receiver.received:
+		case <-deadline.C:
+			t.Fatalf("%s receiver got %d gelf messages instead of %d after %s, errors: %v", receiver.transport, len(messages), count, Timeout, receiver.Errors())
+			return messages
+		}
+	}
+}
+
+// receive decodes a payload, compressed or not, and records the message
+func (receiver *Receiver) receive(payload []byte) {
+	message, err := decode(payload)
+	receiver.mutex.Lock()
+	if err != nil {
+		receiver.errs = append(receiver.errs, err)
+	} else {
+		message.Transport = receiver.transport
+		receiver.messages = append(receiver.messages, message)
+	}
+	receiver.mutex.Unlock()
+
+	select {
+	case receiver.received <- struct{}{}:
+	default:
+	}
+}
+
+// fail records an error that is not tied to a payload, ex: an incomplete chunked message
+func (receiver *Receiver) fail(err error) {
+	receiver.mutex.Lock()
+	receiver.errs = append(receiver.errs, err)
+	receiver.mutex.Unlock()
+}
+
+// decode decompresses a payload if needed and decodes the GELF json
+func decode(payload []byte) (Message, error) {
+	payload, err := decompress(payload)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return Message{}, fmt.Errorf("invalid gelf json %q: %w", payload, err)
+	}
+
+	message := Message{Fields: fields}
+	message.ShortMessage = message.String("short_message")
+	if message.ShortMessage == "" {
+		message.ShortMessage = message.String("message")
+	}
+	message.FullMessage = message.String("full_message")
+	if level := message.String("level"); level != "" {
+		message.Level, err = strconv.Atoi(level)
+		if err != nil {
+			return Message{}, fmt.Errorf("invalid gelf level %q", level)
+		}
+	}
+	return message, nil
+}
+
+// decompress inflates the zlib and gzip payloads, recognized by their magic bytes
+func decompress(payload []byte) ([]byte, error) {
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	case len(payload) >= 2 && payload[0] == 0x78 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		reader, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+	return payload, nil
+}
@@ -0,0 +1,68 @@
+package gelftest
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// NewTCPReceiver starts a GELF tcp receiver on a random port of the loopback interface,
+// the messages are separated by a null byte as in Graylog
+func NewTCPReceiver() (*Receiver, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	receiver := newReceiver("tcp")
+	receiver.addr = listener.Addr().String()
+
+	var connsMutex sync.Mutex
+	conns := make(map[net.Conn]bool)
+	receiver.closeFunc = func() error {
+		err := listener.Close()
+		connsMutex.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		connsMutex.Unlock()
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connsMutex.Lock()
+			conns[conn] = true
+			connsMutex.Unlock()
+			go func() {
+				receiver.readFrames(conn)
+				connsMutex.Lock()
+				delete(conns, conn)
+				connsMutex.Unlock()
+			}()
+		}
+	}()
+	return receiver, nil
+}
+
+// readFrames reads the null terminated messages until the connection is closed
+func (receiver *Receiver) readFrames(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := reader.ReadBytes(0)
+		if len(frame) > 0 && frame[len(frame)-1] == 0 {
+			frame = frame[:len(frame)-1]
+		}
+		if len(frame) > 0 {
+			receiver.receive(frame)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
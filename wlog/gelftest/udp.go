@@ -0,0 +1,101 @@
+package gelftest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// chunkMagic starts the chunks of the messages split over several datagrams
+var chunkMagic = []byte{0x1e, 0x0f}
+
+// chunkHeaderSize is the magic, the message id, the sequence number and the sequence count
+const chunkHeaderSize = 12
+
+// chunkTimeout is how long the chunks of a message wait for the others, as in Graylog
+const chunkTimeout = 5 * time.Second
+
+// chunkedMessage holds the chunks of a message received so far
+type chunkedMessage struct {
+	chunks    [][]byte
+	received  int
+	firstSeen time.Time
+}
+
+// NewUDPReceiver starts a GELF udp receiver on a random port of the loopback interface, it reassembles the
+// chunked messages and decompresses the zlib and gzip payloads
+// ex : receiver, err := gelftest.NewUDPReceiver(); defer receiver.Close(); wlog.NewGraylog(receiver.Host(), receiver.Port())
+func NewUDPReceiver() (*Receiver, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	receiver := newReceiver("udp")
+	receiver.addr = conn.LocalAddr().String()
+	receiver.closeFunc = conn.Close
+	go receiver.readDatagrams(conn)
+	return receiver, nil
+}
+
+// readDatagrams reads the datagrams until the connection is closed
+func (receiver *Receiver) readDatagrams(conn net.PacketConn) {
+	pending := make(map[string]*chunkedMessage)
+	buffer := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		datagram := append([]byte(nil), buffer[:n]...)
+		if !bytes.HasPrefix(datagram, chunkMagic) {
+			receiver.receive(datagram)
+			continue
+		}
+		if payload := receiver.reassemble(pending, datagram); payload != nil {
+			receiver.receive(payload)
+		}
+	}
+}
+
+// reassemble stores a chunk and returns the payload once all the chunks of its message are received
+func (receiver *Receiver) reassemble(pending map[string]*chunkedMessage, chunk []byte) []byte {
+	for id, message := range pending {
+		if time.Since(message.firstSeen) > chunkTimeout {
+			receiver.fail(fmt.Errorf("chunked message %x incomplete after %s: %d/%d chunks", id, chunkTimeout, message.received, len(message.chunks)))
+			delete(pending, id)
+		}
+	}
+
+	if len(chunk) < chunkHeaderSize {
+		receiver.fail(fmt.Errorf("chunk too short: %d bytes", len(chunk)))
+		return nil
+	}
+	id := string(chunk[2:10])
+	sequence, count := int(chunk[10]), int(chunk[11])
+	if count == 0 || count > 128 || sequence >= count {
+		receiver.fail(fmt.Errorf("invalid chunk %d/%d of message %x", sequence, count, id))
+		return nil
+	}
+
+	message, ok := pending[id]
+	if !ok {
+		message = &chunkedMessage{chunks: make([][]byte, count), firstSeen: time.Now()}
+		pending[id] = message
+	}
+	if len(message.chunks) != count {
+		receiver.fail(fmt.Errorf("chunk count of message %x changed from %d to %d", id, len(message.chunks), count))
+		return nil
+	}
+	if message.chunks[sequence] == nil {
+		message.chunks[sequence] = chunk[chunkHeaderSize:]
+		message.received++
+	}
+	if message.received < count {
+		return nil
+	}
+
+	delete(pending, id)
+	return bytes.Join(message.chunks, nil)
+}
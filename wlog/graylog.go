@@ -1,22 +1,18 @@
 package wlog
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"regexp"
-	"runtime"
-	"runtime/debug"
 	"strconv"
-	"strings"
 
 	gelf "github.com/robertkowalski/graylog-golang"
-	"github.com/webediads/adsgolib/wcontext"
 )
 
 // Graylog is our connection to Graylog
 type Graylog struct {
 	gelfConnection *gelf.Gelf
+	metrics        *sinkMetrics
 }
 
 // NewGraylog will instantiate our logger, setup the graylog connection
@@ -26,6 +22,7 @@ func NewGraylog(graylogIPStr string, graylogPortStr string) *Graylog {
 		panic(err.Error())
 	}
 	loggerGraylog := new(Graylog)
+	loggerGraylog.metrics = metricsFor("graylog", "")
 	loggerGraylog.gelfConnection = gelf.New(gelf.Config{
 		GraylogHostname: graylogIPStr,
 		GraylogPort:     graylogPort,
@@ -35,7 +32,7 @@ func NewGraylog(graylogIPStr string, graylogPortStr string) *Graylog {
 
 // Critical is used for errors that cannot be recovered
 func (logger *Graylog) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
@@ -45,7 +42,7 @@ func (logger *Graylog) Critical(msg string, w http.ResponseWriter, r *http.Reque
 
 // Error is used for errors that cannot be recovered but we can still live with them
 func (logger *Graylog) Error(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelError, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
@@ -55,7 +52,7 @@ func (logger *Graylog) Error(msg string, w http.ResponseWriter, r *http.Request)
 
 // NotFound is used when a content or corresponding value was not found
 func (logger *Graylog) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("404 - not found"))
@@ -65,88 +62,44 @@ func (logger *Graylog) NotFound(msg string, w http.ResponseWriter, r *http.Reque
 
 // Warning is used for errors that have been recovered
 func (logger *Graylog) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
 	return nil
 }
 
 // Notice is mainly used internally for debugging to console
 func (logger *Graylog) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
 	return nil
 }
 
 // Debug is mainly used internally for debugging to console
 func (logger *Graylog) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	return nil
 }
 
-// sendToGraylog formats and sends a message to graylog along with the filename, line number, etc
-func (logger *Graylog) sendToDestination(msg string, r *http.Request) {
-	pc, _, _, ok1 := runtime.Caller(1)
-	details := runtime.FuncForPC(pc)
-	if ok1 && details != nil {
-
-		fmt.Println("error sent to Graylog")
-
-		// details.Name() contient le nom de la méthode appelée juste avant
-		regex, _ := regexp.Compile(".[a-z]+$")
-		previousFunction := regex.FindString(details.Name())
-		if previousFunction != "" {
-			previousFunction = strings.ToLower(previousFunction)
-			logLevel, levelFound := logLevels[previousFunction]
-			if !levelFound {
-				logLevel = 7
-			}
-
-			_, fileName, lineNumber, ok2 := runtime.Caller(2)
-
-			var ok3 = true
-			if ok2 {
-				if strings.HasSuffix(fileName, "recoverer.go") {
-					_, fileName, lineNumber, ok3 = runtime.Caller(5)
-				}
-			}
-			if ok3 {
-
-				// default values
-				logIP := "unknown"
-				logReferer := "unknown"
-				logUserAgent := "unknown"
-				logURL := "unknown"
-
-				if r != nil {
-					ctx := r.Context()
-					logIP, _ = ctx.Value(wcontext.ContextKeyRequestIP).(string)
-					logReferer, _ = ctx.Value(wcontext.ContextKeyReferer).(string)
-					logUserAgent, _ = ctx.Value(wcontext.ContextKeyUserAgent).(string)
-					logURL = r.URL.RequestURI()
-				}
-
-				debugStack := debug.Stack()
-
-				errorToLog := errorGelf{
-					App:          Logger.appName,
-					AppGroup:     Logger.appGroupName,
-					ShortMessage: msg,
-					FullMessage:  string(debugStack),
-					IPAddress:    logIP,
-					Level:        logLevel,
-					Line:         lineNumber,
-					Source:       fileName,
-					URL:          logURL,
-					URLReferer:   logReferer,
-					UserAgent:    logUserAgent,
-				}
+// Send formats and sends an entry to graylog along with the filename, line number, etc
+func (logger *Graylog) Send(entry *Entry) error {
+	if entry == nil || !logger.metrics.accept(entry) {
+		return nil
+	}
 
-				errorToLogJSON, errJSON := json.Marshal(errorToLog)
-				if errJSON == nil {
-					logger.gelfConnection.Log(string(errorToLogJSON))
-				}
+	errorToLogJSON, errJSON := json.Marshal(entry.gelf())
+	if errJSON != nil {
+		return logger.metrics.done(entry.Level, errJSON)
+	}
+	logger.gelfConnection.Log(string(errorToLogJSON))
+	return logger.metrics.done(entry.Level, nil)
+}
 
-			}
-		}
 
-	}
+// Flush does nothing, the entries are sent synchronously over udp
+func (logger *Graylog) Flush(ctx context.Context) error {
+	return nil
+}
 
+// Close does nothing, the gelf library does not keep a connection open
+func (logger *Graylog) Close() error {
+	logger.metrics.unregister()
+	return nil
 }
@@ -0,0 +1,376 @@
+package wlog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// KafkaFormatGelf serializes the entries as the GELF payloads we send to Graylog
+const KafkaFormatGelf = "gelf"
+
+// KafkaFormatJSON serializes the entries as they are
+const KafkaFormatJSON = "json"
+
+// KafkaSettings is the struct that is used for configuring the kafka destination
+type KafkaSettings struct {
+	Name         string        // name of the destination in Stats, default kafka, then kafka-2...
+	Format       string        // KafkaFormatGelf (default) or KafkaFormatJSON
+	QueueSize    int           // entries waiting to be published, default 10000
+	BatchSize    int           // entries published at once, default 100
+	BatchTimeout time.Duration // max time an entry waits for its batch, default 1s
+	WriteTimeout time.Duration // max time for publishing a batch, default 10s
+	MaxRetries   int           // new attempts after a failed batch, default 2
+	RetryAfter   time.Duration // time spent on the fallback once the broker failed, default 30s
+	Fallback     ILogger       // receives the entries that could not be published, they are lost if nil
+	// Balancer is the one the writer was built with, ex: the one of wconnectors.KafkaWriterSettings, nil for the
+	// default of kafka-go. The stats of the destination carry a warning unless it hashes the key
+	Balancer kafka.Balancer
+}
+
+// Kafka publishes the entries to a kafka topic, asynchronously and by batches
+type Kafka struct {
+	writer           *kafka.Writer
+	settings         KafkaSettings
+	queue            chan *Entry
+	unavailableUntil time.Time
+	metrics          *sinkMetrics
+	flushes          chan kafkaFlush
+	mutex            sync.RWMutex // held by Send while it queues, so that Close cannot stop the goroutine meanwhile
+	closed           bool
+	done             chan struct{}
+	stopped          chan struct{}
+}
+
+// NewKafka will instantiate our logger on top of a kafka writer, usually the one registered in wconnectors
+// ex : wlog.NewKafka(wconnectors.KafkaWriter("logs"), wlog.KafkaSettings{Fallback: wlog.NewConsole()})
+// the topic should be registered with a kafka.Hash balancer so that the entries of an app stay in order,
+// the stats of the destination carry a warning when the Balancer of the settings does not hash the key
+// ex : wlog.NewKafka(wconnectors.KafkaWriter("logs"), wlog.KafkaSettings{Balancer: &kafka.Hash{}})
+func NewKafka(writer *kafka.Writer, settingsOpt ...KafkaSettings) *Kafka {
+	var settings KafkaSettings
+	if len(settingsOpt) > 0 {
+		settings = settingsOpt[0]
+	}
+	if settings.Format == "" {
+		settings.Format = KafkaFormatGelf
+	}
+	if settings.QueueSize <= 0 {
+		settings.QueueSize = 10000
+	}
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = 100
+	}
+	if settings.BatchTimeout <= 0 {
+		settings.BatchTimeout = time.Second
+	}
+	if settings.WriteTimeout <= 0 {
+		settings.WriteTimeout = 10 * time.Second
+	}
+	if settings.MaxRetries < 0 {
+		settings.MaxRetries = 0
+	} else if settings.MaxRetries == 0 {
+		settings.MaxRetries = 2
+	}
+	if settings.RetryAfter <= 0 {
+		settings.RetryAfter = 30 * time.Second
+	}
+
+	loggerKafka := new(Kafka)
+	loggerKafka.writer = writer
+	loggerKafka.settings = settings
+	loggerKafka.queue = make(chan *Entry, settings.QueueSize)
+	loggerKafka.flushes = make(chan kafkaFlush)
+	loggerKafka.done = make(chan struct{})
+	loggerKafka.stopped = make(chan struct{})
+	loggerKafka.metrics = metricsFor("kafka", settings.Name)
+	if writer != nil && !hashesKey(settings.Balancer) {
+		loggerKafka.metrics.warn("the balancer of the writer does not hash the key, the entries of an app are not kept in order")
+	}
+	loggerKafka.metrics.watchQueue(func() (int, int) {
+		return len(loggerKafka.queue), cap(loggerKafka.queue)
+	})
+	go loggerKafka.run()
+	return loggerKafka
+}
+
+// Critical is used for errors that cannot be recovered
+func (logger *Kafka) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
+	if w != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
+	}
+	return nil
+}
+
+// Error is used for errors that cannot be recovered but we can still live with them
+func (logger *Kafka) Error(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelError, msg, r, 1))
+	if w != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
+	}
+	return nil
+}
+
+// NotFound is used when a content or corresponding value was not found
+func (logger *Kafka) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	if w != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - not found"))
+	}
+	return nil
+}
+
+// Warning is used for errors that have been recovered
+func (logger *Kafka) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
+	return nil
+}
+
+// Notice is mainly used internally for debugging to console
+func (logger *Kafka) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
+	return nil
+}
+
+// Debug is mainly used internally for debugging to console
+func (logger *Kafka) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	return nil
+}
+
+// Send queues an entry, it goes straight to the fallback if the queue is full or the destination is closed
+func (logger *Kafka) Send(entry *Entry) error {
+	if entry == nil || !logger.metrics.accept(entry) {
+		return nil
+	}
+
+	logger.mutex.RLock()
+	queued := false
+	if !logger.closed {
+		select {
+		case logger.queue <- entry:
+			queued = true
+		default:
+		}
+	}
+	logger.mutex.RUnlock()
+
+	if !queued {
+		logger.metrics.dropped(entry.Level)
+		logger.fallback([]*Entry{entry})
+	}
+	return nil
+}
+
+// Flush publishes the queued entries and flushes the fallback, it returns an *UndeliveredError
+// counting the entries that were lost or still queued when ctx expired
+func (logger *Kafka) Flush(ctx context.Context) error {
+	flush := kafkaFlush{ctx: ctx, lost: make(chan int, 1)}
+	select {
+	case logger.flushes <- flush:
+	case <-logger.stopped:
+		return nil
+	case <-ctx.Done():
+		return &UndeliveredError{Count: len(logger.queue), Err: ctx.Err()}
+	}
+
+	// publish gives up as soon as ctx expires so we always get an answer
+	lost := <-flush.lost
+	var err error
+	if logger.settings.Fallback != nil {
+		err = logger.settings.Fallback.Flush(ctx)
+	}
+	if undelivered, ok := err.(*UndeliveredError); ok {
+		lost += undelivered.Count
+		err = undelivered.Err
+	}
+	if lost > 0 {
+		return &UndeliveredError{Count: lost, Err: err}
+	}
+	return err
+}
+
+// Close stops publishing, the entries still queued and the ones sent afterwards go to the fallback,
+// Flush should be called first. The writer belongs to wconnectors and is not closed
+func (logger *Kafka) Close() error {
+	logger.mutex.Lock()
+	if !logger.closed {
+		logger.closed = true
+		close(logger.done)
+	}
+	logger.mutex.Unlock()
+	<-logger.stopped
+	logger.metrics.unregister()
+	return nil
+}
+
+// kafkaFlush is a flush request handled by the publishing goroutine
+type kafkaFlush struct {
+	ctx  context.Context
+	lost chan int
+}
+
+// run publishes the queued entries once a batch is full or has waited long enough
+func (logger *Kafka) run() {
+	defer close(logger.stopped)
+
+	batch := make([]*Entry, 0, logger.settings.BatchSize)
+	ticker := time.NewTicker(logger.settings.BatchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry := <-logger.queue:
+			batch = append(batch, entry)
+			if len(batch) >= logger.settings.BatchSize {
+				logger.publish(context.Background(), batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				logger.publish(context.Background(), batch)
+				batch = batch[:0]
+			}
+		case flush := <-logger.flushes:
+			flush.lost <- logger.drain(flush.ctx, batch)
+			batch = batch[:0]
+		case <-logger.done:
+			// publishing could block the shutdown, the fallback is faster
+			for _, entry := range batch {
+				logger.metrics.dropped(entry.Level)
+			}
+			logger.fallback(batch)
+			for {
+				select {
+				case entry := <-logger.queue:
+					logger.metrics.dropped(entry.Level)
+					logger.fallback([]*Entry{entry})
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// drain publishes the batch and everything that is queued, by batches, and returns the number of lost entries
+func (logger *Kafka) drain(ctx context.Context, batch []*Entry) int {
+	lost := 0
+	for {
+		for len(batch) < logger.settings.BatchSize {
+			select {
+			case entry := <-logger.queue:
+				batch = append(batch, entry)
+				continue
+			default:
+			}
+			break
+		}
+		if len(batch) == 0 {
+			return lost
+		}
+		lost += logger.publish(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// publish writes a batch to kafka, the app name is the key of the messages,
+// it returns the number of entries that were neither published nor handed to the fallback
+func (logger *Kafka) publish(ctx context.Context, entries []*Entry) int {
+	// the broker failed recently (or we are out of time), we don't wait for another timeout
+	if logger.writer == nil || time.Now().Before(logger.unavailableUntil) || ctx.Err() != nil {
+		for _, entry := range entries {
+			logger.metrics.dropped(entry.Level)
+		}
+		return logger.fallback(entries)
+	}
+
+	lost := 0
+	messages := make([]kafka.Message, 0, len(entries))
+	published := make([]*Entry, 0, len(entries))
+	for _, entry := range entries {
+		value, err := logger.marshal(entry)
+		if err != nil {
+			logger.metrics.done(entry.Level, err)
+			lost++
+			continue
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(entry.App),
+			Value: value,
+			Time:  entry.Time,
+		})
+		published = append(published, entry)
+	}
+
+	var err error
+	for attempt := 0; attempt <= logger.settings.MaxRetries; attempt++ {
+		if attempt > 0 {
+			for _, entry := range published {
+				logger.metrics.retried(entry.Level)
+			}
+			select {
+			case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+			case <-ctx.Done():
+			}
+		}
+		writeCtx, cancel := context.WithTimeout(ctx, logger.settings.WriteTimeout)
+		err = logger.writer.WriteMessages(writeCtx, messages...)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+
+	for _, entry := range published {
+		logger.metrics.done(entry.Level, err)
+	}
+	if err != nil {
+		logger.unavailableUntil = time.Now().Add(logger.settings.RetryAfter)
+		lost += logger.fallback(published)
+	}
+	return lost
+}
+
+// hashesKey tells whether a balancer sends the messages sharing a key to the same partition
+func hashesKey(balancer kafka.Balancer) bool {
+	switch balancer.(type) {
+	case *kafka.Hash, kafka.CRC32Balancer, *kafka.CRC32Balancer, kafka.Murmur2Balancer, *kafka.Murmur2Balancer:
+		return true
+	}
+	return false
+}
+
+// marshal serializes an entry in the configured format
+func (logger *Kafka) marshal(entry *Entry) ([]byte, error) {
+	if logger.settings.Format == KafkaFormatJSON {
+		return json.Marshal(entry)
+	}
+	return json.Marshal(entry.gelf())
+}
+
+// fallback hands the entries that could not be published to the secondary destination,
+// it returns the number of entries it could not deliver either
+func (logger *Kafka) fallback(entries []*Entry) int {
+	if len(entries) == 0 {
+		return 0
+	}
+	if logger.settings.Fallback == nil {
+		return len(entries)
+	}
+	lost := 0
+	for _, entry := range entries {
+		if err := logger.settings.Fallback.Send(entry); err != nil {
+			lost++
+		}
+	}
+	return lost
+}
@@ -0,0 +1,76 @@
+package wlog
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+func TestHashesKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		balancer kafka.Balancer
+		want     bool
+	}{
+		{"default", nil, false},
+		{"least bytes", &kafka.LeastBytes{}, false},
+		{"round robin", &kafka.RoundRobin{}, false},
+		{"hash", &kafka.Hash{}, true},
+		{"crc32", kafka.CRC32Balancer{}, true},
+		{"murmur2", &kafka.Murmur2Balancer{}, true},
+	}
+	for _, test := range tests {
+		if got := hashesKey(test.balancer); got != test.want {
+			t.Errorf("%s: hashesKey() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// lineCounter counts the lines written by a console, it is safe for concurrent use
+type lineCounter struct {
+	mutex sync.Mutex
+	lines int
+}
+
+func (counter *lineCounter) Write(p []byte) (int, error) {
+	counter.mutex.Lock()
+	counter.lines += bytes.Count(p, []byte("\n"))
+	counter.mutex.Unlock()
+	return len(p), nil
+}
+
+func (counter *lineCounter) count() int {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	return counter.lines
+}
+
+func TestKafkaSendDuringClose(t *testing.T) {
+	const senders, entries = 8, 200
+	for run := 0; run < 5; run++ {
+		counter := new(lineCounter)
+		fallback := NewConsole(ConsoleSettings{Name: "kafka close test fallback", Format: ConsoleFormatJSON, Writer: counter})
+		// without a writer every entry ends up in the fallback, through the queue or right away
+		logger := NewKafka(nil, KafkaSettings{Name: "kafka close test", Fallback: fallback, BatchTimeout: time.Hour})
+
+		var wg sync.WaitGroup
+		for i := 0; i < senders; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < entries; j++ {
+					logger.Send(&Entry{Level: LevelCritical, Message: "sent during close"})
+				}
+			}()
+		}
+		logger.Close()
+		wg.Wait()
+
+		if got := counter.count(); got != senders*entries {
+			t.Fatalf("%d entries in the fallback, want %d", got, senders*entries)
+		}
+	}
+}
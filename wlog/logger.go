@@ -1,8 +1,13 @@
 package wlog
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // Wrapper is a struct containing the required resources for logging to screen/logfile/remote syslog
@@ -11,10 +16,12 @@ type Wrapper struct {
 	realLogger   log.Logger
 	appName      string
 	appGroupName string
+	redaction    *RedactionSettings
+	maxLevel     int
 }
 
 // Logger is our application Wrapper object
-var Logger = &Wrapper{}
+var Logger = &Wrapper{maxLevel: LevelDebug}
 
 /*
 0 critical : l'app ne peut pas ou plus fonctionner
@@ -23,12 +30,33 @@ var Logger = &Wrapper{}
 6 notice : hash déjà utilisé, tout ce qui n'empêche pas de continuer ou qui n'est pas une erreur en soi
 7 debug : pour nous, pour comprendre ce qui se passe dans un algo en fonction des paramètres par exemple
 */
-var logLevels = map[string]int{
-	"critical": 0,
-	"error":    3,
-	"warning":  5,
-	"notice":   6,
-	"debug":    7,
+const (
+	// LevelCritical is the level of Critical
+	LevelCritical = 0
+	// LevelError is the level of Error
+	LevelError = 3
+	// LevelWarning is the level of Warning
+	LevelWarning = 5
+	// LevelNotice is the level of Notice
+	LevelNotice = 6
+	// LevelDebug is the level of Debug and NotFound
+	LevelDebug = 7
+)
+
+var levelNames = map[int]string{
+	LevelCritical: "critical",
+	LevelError:    "error",
+	LevelWarning:  "warning",
+	LevelNotice:   "notice",
+	LevelDebug:    "debug",
+}
+
+// LevelName returns the name of a level (critical, error...)
+func LevelName(level int) string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return "level" + strconv.Itoa(level)
 }
 
 // ILogger is our interface for matching our logger systems
@@ -39,7 +67,9 @@ type ILogger interface {
 	Warning(msg string, w http.ResponseWriter, r *http.Request) error
 	Notice(msg string, w http.ResponseWriter, r *http.Request) error
 	Debug(msg string, w http.ResponseWriter, r *http.Request) error
-	sendToDestination(msg string, r *http.Request)
+	Send(entry *Entry) error
+	Flush(ctx context.Context) error
+	Close() error
 }
 
 type errorGelf struct {
@@ -54,6 +84,9 @@ type errorGelf struct {
 	URL          string `json:"url"`
 	URLReferer   string `json:"url_referer"`
 	UserAgent    string `json:"user_agent"`
+	Fingerprint  string `json:"fingerprint"`
+
+	Fields map[string]interface{} `json:"-"`
 }
 
 // SetLogger sets the destination which is a type ILogger
@@ -63,6 +96,82 @@ func SetLogger(destination ILogger, appName string, appGroupName string) {
 	Logger.appGroupName = appGroupName
 }
 
+var errNoDestination = errors.New("wlog: no destination, SetLogger must be called first")
+
+// sendToLogger redacts and sends an entry to the destination set with SetLogger
+func sendToLogger(entry *Entry) error {
+	if Logger.destination == nil {
+		return errNoDestination
+	}
+	if entry == nil {
+		return nil
+	}
+	return Logger.destination.Send(redactEntry(entry))
+}
+
+// SetMaxLevel filters out the entries above a level, ex: LevelNotice ignores the debug entries
+func SetMaxLevel(level int) {
+	Logger.maxLevel = level
+}
+
+// SetDestination replaces the destination, keeping the app names, and returns the previous one
+func SetDestination(destination ILogger) ILogger {
+	previousDestination := Logger.destination
+	Logger.destination = destination
+	return previousDestination
+}
+
+// WriteErrorResponse writes the response our destinations send along with Critical and Error (500) or NotFound (404),
+// the other client errors get the same format as the 404, ex: "400 - bad request"
+func WriteErrorResponse(w http.ResponseWriter, status int) {
+	if w == nil {
+		return
+	}
+	w.WriteHeader(status)
+	if status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+		w.Write([]byte(strconv.Itoa(status) + " - " + strings.ToLower(http.StatusText(status))))
+	} else {
+		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
+	}
+}
+
+// UndeliveredError is returned by Flush and Shutdown when entries could not be delivered
+type UndeliveredError struct {
+	Count int   // entries lost
+	Err   error // why they were lost, ex: context.DeadlineExceeded
+}
+
+func (e *UndeliveredError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("wlog: %d log entries could not be delivered", e.Count)
+	}
+	return fmt.Sprintf("wlog: %d log entries could not be delivered: %s", e.Count, e.Err.Error())
+}
+
+func (e *UndeliveredError) Unwrap() error {
+	return e.Err
+}
+
+// Shutdown flushes and closes the destination, it returns the number of entries that could not be
+// delivered before ctx expired, to be called once the http server is shut down
+// ex : ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second); defer cancel(); wlog.Shutdown(ctx)
+func Shutdown(ctx context.Context) (int, error) {
+	if Logger.destination == nil {
+		return 0, nil
+	}
+
+	undelivered := 0
+	err := Logger.destination.Flush(ctx)
+	var undeliveredErr *UndeliveredError
+	if errors.As(err, &undeliveredErr) {
+		undelivered = undeliveredErr.Count
+	}
+	if closeErr := Logger.destination.Close(); err == nil {
+		err = closeErr
+	}
+	return undelivered, err
+}
+
 // GetLogger returns the destination for quick access
 func GetLogger() ILogger {
 	return Logger.destination
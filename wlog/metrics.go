@@ -0,0 +1,270 @@
+package wlog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelStats holds the counters of a destination for a level
+type LevelStats struct {
+	Emitted   int64 `json:"emitted"`   // entries delivered
+	Filtered  int64 `json:"filtered"`  // entries ignored because of their level
+	Dropped   int64 `json:"dropped"`   // entries not even tried (full queue, broker known to be down), handed to the fallback if any
+	Failed    int64 `json:"failed"`    // entries that could not be delivered
+	Retried   int64 `json:"retried"`   // delivery attempts after a failure
+	Throttled int64 `json:"throttled"` // alerts not sent because their fingerprint already had one recently
+}
+
+// SinkStats holds the counters and the state of a destination
+type SinkStats struct {
+	Levels        map[string]LevelStats `json:"levels"`
+	QueueDepth    int                   `json:"queue_depth"`
+	QueueCapacity int                   `json:"queue_capacity"`
+	LastError     string                `json:"last_error,omitempty"`
+	LastErrorTime time.Time             `json:"last_error_time,omitempty"`
+	LastDropTime  time.Time             `json:"last_drop_time,omitempty"`
+	Warnings      []string              `json:"warnings,omitempty"` // configuration problems, they do not degrade the health
+}
+
+// HealthWindow is how long a destination is reported as degraded after a failure or a drop
+var HealthWindow = time.Minute
+
+// sinkMetrics holds the counters of a destination, it is safe for concurrent use
+type sinkMetrics struct {
+	name          string
+	named         bool // the name was given in the settings, the counters can be shared
+	mutex         sync.Mutex
+	levels        map[int]*LevelStats
+	queue         func() (int, int)
+	lastError     string
+	lastErrorTime time.Time
+	lastDropTime  time.Time
+	warnings      []string
+}
+
+var allSinkMetrics = make(map[string]*sinkMetrics)
+var allSinkMetricsMutex sync.Mutex
+
+// metricsFor returns the counters of a destination, they are listed by Stats under its name.
+// Without a name, every instance gets its own counters named after its kind (kafka, kafka-2, kafka-3...) until it is closed.
+// The instances given the same name share their counters
+func metricsFor(kind string, name string) *sinkMetrics {
+	allSinkMetricsMutex.Lock()
+	defer allSinkMetricsMutex.Unlock()
+	named := name != ""
+	if !named {
+		name = kind
+		for i := 2; allSinkMetrics[name] != nil; i++ {
+			name = kind + "-" + strconv.Itoa(i)
+		}
+	}
+	metrics, ok := allSinkMetrics[name]
+	if !ok {
+		metrics = &sinkMetrics{name: name, named: named, levels: make(map[int]*LevelStats)}
+		allSinkMetrics[name] = metrics
+	}
+	return metrics
+}
+
+// unregister removes the counters of a closed destination from Stats, its name can then be given to a new instance.
+// The counters of a name given in the settings are kept, other instances may share them
+func (metrics *sinkMetrics) unregister() {
+	if metrics == nil || metrics.named {
+		return
+	}
+	allSinkMetricsMutex.Lock()
+	if allSinkMetrics[metrics.name] == metrics {
+		delete(allSinkMetrics, metrics.name)
+	}
+	allSinkMetricsMutex.Unlock()
+}
+
+// accept counts the entries filtered because of their level and tells whether the entry must be sent
+func (metrics *sinkMetrics) accept(entry *Entry) bool {
+	if entry.Level <= Logger.maxLevel {
+		return true
+	}
+	metrics.filtered(entry.Level)
+	return false
+}
+
+// filtered counts an entry ignored because of its level
+func (metrics *sinkMetrics) filtered(level int) {
+	metrics.mutex.Lock()
+	metrics.level(level).Filtered++
+	metrics.mutex.Unlock()
+}
+
+// done counts an entry as emitted, or failed if err is not nil, and returns err
+func (metrics *sinkMetrics) done(level int, err error) error {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if err != nil {
+		metrics.level(level).Failed++
+		metrics.lastError = err.Error()
+		metrics.lastErrorTime = time.Now()
+		return err
+	}
+	metrics.level(level).Emitted++
+	return nil
+}
+
+// failed records a failure that is not the one of an entry
+func (metrics *sinkMetrics) failed(err error) {
+	metrics.mutex.Lock()
+	metrics.lastError = err.Error()
+	metrics.lastErrorTime = time.Now()
+	metrics.mutex.Unlock()
+}
+
+// throttled counts an alert that was not sent because of the throttling
+func (metrics *sinkMetrics) throttled(level int) {
+	metrics.mutex.Lock()
+	metrics.level(level).Throttled++
+	metrics.mutex.Unlock()
+}
+
+// dropped counts an entry that was not even tried
+func (metrics *sinkMetrics) dropped(level int) {
+	metrics.mutex.Lock()
+	metrics.level(level).Dropped++
+	metrics.lastDropTime = time.Now()
+	metrics.mutex.Unlock()
+}
+
+// retried counts a new delivery attempt
+func (metrics *sinkMetrics) retried(level int) {
+	metrics.mutex.Lock()
+	metrics.level(level).Retried++
+	metrics.mutex.Unlock()
+}
+
+// warn records a configuration problem of the destination
+func (metrics *sinkMetrics) warn(warning string) {
+	metrics.mutex.Lock()
+	metrics.warnings = append(metrics.warnings, warning)
+	metrics.mutex.Unlock()
+}
+
+// watchQueue registers the function returning the depth and the capacity of the queue of the destination
+func (metrics *sinkMetrics) watchQueue(queue func() (int, int)) {
+	metrics.mutex.Lock()
+	metrics.queue = queue
+	metrics.mutex.Unlock()
+}
+
+// level returns the counters of a level, the mutex must be held
+func (metrics *sinkMetrics) level(level int) *LevelStats {
+	stats, ok := metrics.levels[level]
+	if !ok {
+		stats = new(LevelStats)
+		metrics.levels[level] = stats
+	}
+	return stats
+}
+
+// stats returns a copy of the counters
+func (metrics *sinkMetrics) stats() SinkStats {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	stats := SinkStats{
+		Levels:        make(map[string]LevelStats, len(metrics.levels)),
+		LastError:     metrics.lastError,
+		LastErrorTime: metrics.lastErrorTime,
+		LastDropTime:  metrics.lastDropTime,
+		Warnings:      append([]string(nil), metrics.warnings...),
+	}
+	for level, levelStats := range metrics.levels {
+		stats.Levels[LevelName(level)] = *levelStats
+	}
+	if metrics.queue != nil {
+		stats.QueueDepth, stats.QueueCapacity = metrics.queue()
+	}
+	return stats
+}
+
+// Stats returns the counters of every destination that was used, by destination name (graylog, kafka, kafka-2...)
+func Stats() map[string]SinkStats {
+	allSinkMetricsMutex.Lock()
+	defer allSinkMetricsMutex.Unlock()
+	stats := make(map[string]SinkStats, len(allSinkMetrics))
+	for name, metrics := range allSinkMetrics {
+		stats[name] = metrics.stats()
+	}
+	return stats
+}
+
+// problems returns why a destination is degraded: it failed or dropped entries during the HealthWindow,
+// or its queue is more than 90% full
+func (stats SinkStats) problems(now time.Time) []string {
+	var problems []string
+	if now.Sub(stats.LastErrorTime) < HealthWindow {
+		problems = append(problems, "failed: "+stats.LastError)
+	}
+	if now.Sub(stats.LastDropTime) < HealthWindow {
+		problems = append(problems, "dropped entries")
+	}
+	if stats.QueueCapacity > 0 && stats.QueueDepth*10 >= stats.QueueCapacity*9 {
+		problems = append(problems, fmt.Sprintf("queue is full (%d/%d)", stats.QueueDepth, stats.QueueCapacity))
+	}
+	return problems
+}
+
+// HealthCheck returns an error describing the degraded destinations: the ones that failed or
+// dropped entries during the HealthWindow, or whose queue is more than 90% full
+func HealthCheck(ctx context.Context) error {
+	var problems []string
+	now := time.Now()
+	for name, stats := range Stats() {
+		for _, problem := range stats.problems(now) {
+			problems = append(problems, name+" "+problem)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("logging is degraded: %s", strings.Join(problems, ", "))
+}
+
+// HealthHandler responds 200 when logging is healthy and 503 otherwise, for readiness probes.
+// The body has a line per destination with its problems and its warnings, ex: kafka-2: failed: ...
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		allStats := Stats()
+		names := make([]string, 0, len(allStats))
+		for name := range allStats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		healthy := true
+		var body strings.Builder
+		for _, name := range names {
+			problems := allStats[name].problems(now)
+			if len(problems) == 0 {
+				problems = []string{"ok"}
+			} else {
+				healthy = false
+			}
+			for _, warning := range allStats[name].Warnings {
+				problems = append(problems, "warning: "+warning)
+			}
+			body.WriteString(name + ": " + strings.Join(problems, ", ") + "\n")
+		}
+		if len(names) == 0 {
+			body.WriteString("ok")
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte(body.String()))
+	})
+}
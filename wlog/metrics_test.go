@@ -0,0 +1,96 @@
+package wlog
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsForInstances(t *testing.T) {
+	first := metricsFor("testsink", "")
+	second := metricsFor("testsink", "")
+	defer first.unregister()
+	defer second.unregister()
+	named := metricsFor("testsink", "testsink-named")
+	if first == second || first == named || second == named {
+		t.Fatal("the instances share their counters")
+	}
+	if shared := metricsFor("testsink", "testsink-named"); shared != named {
+		t.Error("the instances given the same name do not share their counters")
+	}
+
+	second.done(LevelError, errors.New("connection refused"))
+	stats := Stats()
+	for _, name := range []string{"testsink", "testsink-2", "testsink-named"} {
+		if _, ok := stats[name]; !ok {
+			t.Errorf("Stats() has no %s", name)
+		}
+	}
+	if stats["testsink"].LastError != "" || stats["testsink-2"].LastError != "connection refused" {
+		t.Errorf("the failure of testsink-2 is reported as %q and %q", stats["testsink"].LastError, stats["testsink-2"].LastError)
+	}
+
+	recorder := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	body := recorder.Body.String()
+	for _, line := range []string{"testsink: ok\n", "testsink-2: failed: connection refused\n"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("body %q has no line %q", body, line)
+		}
+	}
+}
+
+func TestMetricsUnregister(t *testing.T) {
+	first := metricsFor("testclose", "")
+	second := metricsFor("testclose", "")
+	named := metricsFor("testclose", "testclose-named")
+	if first.name != "testclose" || second.name != "testclose-2" {
+		t.Fatalf("the counters are named %s and %s, want testclose and testclose-2", first.name, second.name)
+	}
+
+	first.unregister()
+	named.unregister()
+	stats := Stats()
+	if _, ok := stats["testclose"]; ok {
+		t.Error("Stats() still has testclose once it is unregistered")
+	}
+	for _, name := range []string{"testclose-2", "testclose-named"} {
+		if _, ok := stats[name]; !ok {
+			t.Errorf("Stats() has no %s", name)
+		}
+	}
+
+	// the name is given to the next instance, the counters of the closed one are not reused
+	third := metricsFor("testclose", "")
+	defer third.unregister()
+	if third.name != "testclose" || third == first {
+		t.Errorf("the next instance got %s, want new testclose counters", third.name)
+	}
+	first.unregister()
+	if _, ok := Stats()["testclose"]; !ok {
+		t.Error("closing an instance twice removed the counters of the next one")
+	}
+
+	second.unregister()
+	if _, ok := Stats()["testclose-2"]; ok {
+		t.Error("Stats() still has testclose-2 once it is unregistered")
+	}
+}
+
+func TestCloseUnregistersMetrics(t *testing.T) {
+	console := NewConsole(ConsoleSettings{Writer: ioutil.Discard})
+	name := console.metrics.name
+	if _, ok := Stats()[name]; !ok {
+		t.Fatalf("Stats() has no %s", name)
+	}
+	console.Close()
+	if _, ok := Stats()[name]; ok {
+		t.Errorf("Stats() still has %s once the console is closed", name)
+	}
+}
@@ -2,23 +2,20 @@ package wlog
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
-	"regexp"
-	"runtime"
-	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/webediads/adsgolib/wcontext"
 )
 
 // ProxyGelf is our connection to Graylog
 type ProxyGelf struct {
-	url string
+	url     string
+	metrics *sinkMetrics
 }
 
 // NewProxyGelf will instantiate our logger
@@ -26,12 +23,13 @@ func NewProxyGelf(url string) *ProxyGelf {
 
 	loggerProxyGelf := new(ProxyGelf)
 	loggerProxyGelf.url = url
+	loggerProxyGelf.metrics = metricsFor("proxygelf", "")
 	return loggerProxyGelf
 }
 
 // Critical is used for errors that cannot be recovered
 func (logger *ProxyGelf) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
@@ -41,7 +39,7 @@ func (logger *ProxyGelf) Critical(msg string, w http.ResponseWriter, r *http.Req
 
 // Error is used for errors that cannot be recovered but we can still live with them
 func (logger *ProxyGelf) Error(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelError, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
@@ -51,7 +49,7 @@ func (logger *ProxyGelf) Error(msg string, w http.ResponseWriter, r *http.Reques
 
 // NotFound is used when a content or corresponding value was not found
 func (logger *ProxyGelf) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("404 - not found"))
@@ -61,90 +59,45 @@ func (logger *ProxyGelf) NotFound(msg string, w http.ResponseWriter, r *http.Req
 
 // Warning is used for errors that have been recovered
 func (logger *ProxyGelf) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
 	return nil
 }
 
 // Notice is mainly used internally for debugging to console
 func (logger *ProxyGelf) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
 	return nil
 }
 
 // Debug is mainly used internally for debugging to console
 func (logger *ProxyGelf) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	return nil
 }
 
-// sendToGraylog formats and sends a message to graylog along with the filename, line number, etc
-func (logger *ProxyGelf) sendToDestination(msg string, r *http.Request) {
-	pc, _, _, ok1 := runtime.Caller(1)
-	details := runtime.FuncForPC(pc)
-	if !ok1 || details == nil {
-		// fmt.Println("problem ok1 or details")
-		return
-	}
-
-	// details.Name() contient le nom de la méthode appelée juste avant
-	regex, _ := regexp.Compile(".[a-z]+$")
-	previousFunction := regex.FindString(details.Name())
-	if previousFunction == "" {
-		// fmt.Println("problem previousFunction")
-		return
-	}
-
-	previousFunction = strings.ToLower(previousFunction)
-	logLevel, levelFound := logLevels[previousFunction]
-	if !levelFound {
-		logLevel = 7
-	}
-	_, fileName, lineNumber, ok2 := runtime.Caller(2)
-
-	var ok3 = true
-	if ok2 {
-		if strings.HasSuffix(fileName, "recoverer.go") {
-			_, fileName, lineNumber, ok3 = runtime.Caller(5)
-		}
-	}
-	if !ok3 {
-		// fmt.Println("problem ok3")
-		return
-	}
-
-	// default values
-	logIP := "unknown"
-	logReferer := "unknown"
-	logUserAgent := "unknown"
-	logURL := "unknown"
-
-	if r != nil {
-		ctx := r.Context()
-		logIP, _ = ctx.Value(wcontext.ContextKeyRequestIP).(string)
-		logReferer, _ = ctx.Value(wcontext.ContextKeyReferer).(string)
-		logUserAgent, _ = ctx.Value(wcontext.ContextKeyUserAgent).(string)
-		logURL = r.URL.RequestURI()
+// Send formats and sends an entry to the gelf proxy along with the filename, line number, etc
+func (logger *ProxyGelf) Send(entry *Entry) error {
+	if entry == nil || !logger.metrics.accept(entry) {
+		return nil
 	}
-	debugStack := debug.Stack()
 
 	requestBody, err := json.Marshal(map[string]string{
-		"app":          Logger.appName,
-		"app_group":    Logger.appGroupName,
-		"message":      msg,
-		"level":        strconv.Itoa(logLevel),
-		"full_message": strings.Replace(string(debugStack), "[", "", -2), // api aime pas le caractère [, ça casse son json_decode
-		"ip_address":   logIP,
-		"line":         strconv.Itoa(lineNumber),
-		"file":         fileName,
-		"url":          logURL,
-		"url_referer":  logReferer,
-		"user_agent":   logUserAgent,
+		"app":          entry.App,
+		"app_group":    entry.AppGroup,
+		"message":      entry.Message,
+		"level":        strconv.Itoa(entry.Level),
+		"full_message": strings.Replace(entry.FullMessage, "[", "", -2), // api aime pas le caractère [, ça casse son json_decode
+		"ip_address":   entry.IPAddress,
+		"line":         strconv.Itoa(entry.Line),
+		"file":         entry.File,
+		"url":          entry.URL,
+		"url_referer":  entry.URLReferer,
+		"user_agent":   entry.UserAgent,
+		"fingerprint":  entry.Fingerprint,
 	})
 	if err != nil {
-		fmt.Println("error json.Marshal")
-		return
+		return logger.metrics.done(entry.Level, err)
 	}
-	// fmt.Println(string(requestBody))
 
 	transport := http.Transport{
 		Dial: dialTimeout,
@@ -152,17 +105,30 @@ func (logger *ProxyGelf) sendToDestination(msg string, r *http.Request) {
 	client := http.Client{
 		Transport: &transport,
 	}
-	_, err = client.Post(logger.url, "application/x-www-form-urlencoded", bytes.NewBuffer(requestBody))
+	defer transport.CloseIdleConnections()
+	response, err := client.Post(logger.url, "application/x-www-form-urlencoded", bytes.NewBuffer(requestBody))
 	if err != nil {
-		fmt.Println("error post")
-	} else {
-		fmt.Println("msg send " + msg)
+		return logger.metrics.done(entry.Level, err)
 	}
-	transport.CloseIdleConnections()
-
+	response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return logger.metrics.done(entry.Level, fmt.Errorf("gelf proxy responded with status %d", response.StatusCode))
+	}
+	return logger.metrics.done(entry.Level, nil)
 }
 
 func dialTimeout(network, addr string) (net.Conn, error) {
 	var timeout = time.Duration(3 * time.Second)
 	return net.DialTimeout(network, addr, timeout)
 }
+
+// Flush does nothing, the entries are sent synchronously
+func (logger *ProxyGelf) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, the connections are closed after each entry
+func (logger *ProxyGelf) Close() error {
+	logger.metrics.unregister()
+	return nil
+}
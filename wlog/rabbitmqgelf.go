@@ -1,25 +1,22 @@
 package wlog
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"regexp"
-	"runtime"
-	"runtime/debug"
-	"strings"
 
 	"github.com/streadway/amqp"
-	"github.com/webediads/adsgolib/wcontext"
 )
 
 // RabbitMqGelf is our connection to Graylog
 type RabbitMqGelf struct {
+	conn    *amqp.Connection
 	channel *amqp.Channel
+	metrics *sinkMetrics
 }
 
 // NewRabbitMqGelf will instantiate our logger, setup the rabbitmq connection and channel
@@ -65,14 +62,14 @@ func NewRabbitMqGelf(rabbitGelfProtocolStr string, rabbitGelfHostStr string, rab
 	if err != nil {
 		failOnError(err, "Failed to connect to RabbitMQ")
 	}
-	// defer conn.Close()
 
 	ch, err := conn.Channel()
 	failOnError(err, "Failed to open a channel")
-	// defer ch.Close()
 
 	loggerRabbitMqGelf := new(RabbitMqGelf)
+	loggerRabbitMqGelf.conn = conn
 	loggerRabbitMqGelf.channel = ch
+	loggerRabbitMqGelf.metrics = metricsFor("rabbitmqgelf", "")
 	return loggerRabbitMqGelf
 }
 
@@ -84,7 +81,7 @@ func failOnError(err error, msg string) {
 
 // Critical is used for errors that cannot be recovered
 func (logger *RabbitMqGelf) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
@@ -94,7 +91,7 @@ func (logger *RabbitMqGelf) Critical(msg string, w http.ResponseWriter, r *http.
 
 // Error is used for errors that cannot be recovered but we can still live with them
 func (logger *RabbitMqGelf) Error(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelError, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Software Failure. Press left mouse button to continue.\nGuru Meditation #00000025.65045338"))
@@ -104,7 +101,7 @@ func (logger *RabbitMqGelf) Error(msg string, w http.ResponseWriter, r *http.Req
 
 // NotFound is used when a content or corresponding value was not found
 func (logger *RabbitMqGelf) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	if w != nil {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("404 - not found"))
@@ -114,108 +111,67 @@ func (logger *RabbitMqGelf) NotFound(msg string, w http.ResponseWriter, r *http.
 
 // Warning is used for errors that have been recovered
 func (logger *RabbitMqGelf) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
 	return nil
 }
 
 // Notice is mainly used internally for debugging to console
 func (logger *RabbitMqGelf) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
 	return nil
 }
 
 // Debug is mainly used internally for debugging to console
 func (logger *RabbitMqGelf) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
-	logger.sendToDestination(msg, r)
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
 	return nil
 }
 
-// sendToGraylog formats and sends a message to graylog along with the filename, line number, etc
-func (logger *RabbitMqGelf) sendToDestination(msg string, r *http.Request) {
-	pc, _, _, ok1 := runtime.Caller(1)
-	details := runtime.FuncForPC(pc)
-	if ok1 && details != nil {
-
-		fmt.Println("error sent to Rabbit")
-
-		// details.Name() contient le nom de la méthode appelée juste avant
-		regex, _ := regexp.Compile(".[a-z]+$")
-		previousFunction := regex.FindString(details.Name())
-		if previousFunction != "" {
-			previousFunction = strings.ToLower(previousFunction)
-			logLevel, levelFound := logLevels[previousFunction]
-			if !levelFound {
-				logLevel = 7
-			}
-
-			_, fileName, lineNumber, ok2 := runtime.Caller(2)
-
-			var ok3 = true
-			if ok2 {
-				if strings.HasSuffix(fileName, "recoverer.go") {
-					_, fileName, lineNumber, ok3 = runtime.Caller(5)
-				}
-			}
-			if ok3 {
-
-				q, err := logger.channel.QueueDeclare(
-					"log-messages", // name
-					true,           // durable
-					false,          // delete when unused
-					false,          // exclusive
-					false,          // no-wait
-					nil,            // arguments
-				)
-				failOnError(err, "Failed to declare a queue")
-
-				// default values
-				logIP := "unknown"
-				logReferer := "unknown"
-				logUserAgent := "unknown"
-				logURL := "unknown"
-
-				if r != nil {
-					ctx := r.Context()
-					logIP, _ = ctx.Value(wcontext.ContextKeyRequestIP).(string)
-					logReferer, _ = ctx.Value(wcontext.ContextKeyReferer).(string)
-					logUserAgent, _ = ctx.Value(wcontext.ContextKeyUserAgent).(string)
-					logURL = r.URL.RequestURI()
-				}
-
-				debugStack := debug.Stack()
-
-				errorToLog := errorGelf{
-					App:          Logger.appName,
-					AppGroup:     Logger.appGroupName,
-					ShortMessage: msg,
-					FullMessage:  string(debugStack),
-					IPAddress:    logIP,
-					Level:        logLevel,
-					Line:         lineNumber,
-					Source:       fileName,
-					URL:          logURL,
-					URLReferer:   logReferer,
-					UserAgent:    logUserAgent,
-				}
-				// fmt.Println(errorToLog)
-
-				errorToLogJSON, errJSON := json.Marshal(errorToLog)
-				if errJSON == nil {
-					err = logger.channel.Publish(
-						"",     // exchange
-						q.Name, // routing key
-						false,  // mandatory
-						false,  // immediate
-						amqp.Publishing{
-							ContentType: "text/plain",
-							Body:        []byte(errorToLogJSON),
-						})
-					failOnError(err, "Failed to publish a message")
-				}
-
-			}
-		}
+// Send formats and sends an entry to rabbitmq along with the filename, line number, etc
+func (logger *RabbitMqGelf) Send(entry *Entry) error {
+	if entry == nil || !logger.metrics.accept(entry) {
+		return nil
+	}
+
+	q, err := logger.channel.QueueDeclare(
+		"log-messages", // name
+		true,           // durable
+		false,          // delete when unused
+		false,          // exclusive
+		false,          // no-wait
+		nil,            // arguments
+	)
+	if err != nil {
+		return logger.metrics.done(entry.Level, err)
+	}
 
+	errorToLogJSON, errJSON := json.Marshal(entry.gelf())
+	if errJSON != nil {
+		return logger.metrics.done(entry.Level, errJSON)
 	}
+	err = logger.channel.Publish(
+		"",     // exchange
+		q.Name, // routing key
+		false,  // mandatory
+		false,  // immediate
+		amqp.Publishing{
+			ContentType: "text/plain",
+			Body:        []byte(errorToLogJSON),
+		})
+	return logger.metrics.done(entry.Level, err)
+}
 
+// Flush does nothing, the entries are published synchronously
+func (logger *RabbitMqGelf) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the channel and the connection to rabbitmq
+func (logger *RabbitMqGelf) Close() error {
+	logger.metrics.unregister()
+	err := logger.channel.Close()
+	if connErr := logger.conn.Close(); err == nil {
+		err = connErr
+	}
+	return err
 }
@@ -0,0 +1,69 @@
+package wlog
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// RabbitMqGelf needs an amqp broker, gelftest has none, so its field mapping is checked on the body it publishes
+func TestRabbitMqGelfPayload(t *testing.T) {
+	entry := &Entry{
+		App:         "myapp",
+		AppGroup:    "mygroup",
+		Level:       LevelError,
+		Message:     "article not saved",
+		FullMessage: "main.save\n\tmain.go:12",
+		Fingerprint: "0123456789abcdef",
+		File:        "main.go",
+		Line:        12,
+		IPAddress:   "10.0.0.1",
+		URL:         "/article/12",
+		URLReferer:  "https://example.com/",
+		UserAgent:   "wlog",
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		extra  map[string]interface{}
+	}{
+		{"no fields", nil, nil},
+		{"fields", map[string]interface{}{"article": "12"}, map[string]interface{}{"article": "12"}},
+		{"standard keys win", map[string]interface{}{"app": "other", "level": 7}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry.Fields = test.fields
+			body, err := json.Marshal(entry.gelf())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload map[string]interface{}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := map[string]interface{}{
+				"app":           "myapp",
+				"app_group":     "mygroup",
+				"short_message": "article not saved",
+				"full_message":  "main.save\n\tmain.go:12",
+				"ip_address":    "10.0.0.1",
+				"level":         float64(LevelError),
+				"line":          float64(12),
+				"source":        "main.go",
+				"url":           "/article/12",
+				"url_referer":   "https://example.com/",
+				"user_agent":    "wlog",
+				"fingerprint":   "0123456789abcdef",
+			}
+			for key, value := range test.extra {
+				expected[key] = value
+			}
+			if !reflect.DeepEqual(payload, expected) {
+				t.Errorf("payload = %v, want %v", payload, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+//go:build go1.21
+// +build go1.21
+
+package wlog
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// SlogHandler is a slog.Handler sending the records to the destination set with SetLogger
+// ex : slog.SetDefault(slog.New(wlog.NewSlogHandler(slog.LevelInfo)))
+type SlogHandler struct {
+	level  slog.Leveler
+	attrs  map[string]interface{}
+	prefix string
+}
+
+// NewSlogHandler will instantiate our handler, the records under the level (default slog.LevelInfo) are ignored
+func NewSlogHandler(levelOpt ...slog.Leveler) *SlogHandler {
+	var level slog.Leveler = slog.LevelInfo
+	if len(levelOpt) > 0 && levelOpt[0] != nil {
+		level = levelOpt[0]
+	}
+	return &SlogHandler{level: level}
+}
+
+// Enabled reports whether the handler handles records at the given level
+func (handler *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= handler.level.Level()
+}
+
+// Handle sends a record as an entry, the attributes become fields
+func (handler *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if Logger.destination == nil {
+		return errNoDestination
+	}
+
+	entry := &Entry{
+		Time:       record.Time,
+		App:        Logger.appName,
+		AppGroup:   Logger.appGroupName,
+		Level:      levelFromSlog(record.Level),
+		Message:    record.Message,
+		IPAddress:  "unknown",
+		URL:        "unknown",
+		URLReferer: "unknown",
+		UserAgent:  "unknown",
+	}
+	var stack []uintptr
+	if record.PC != 0 {
+		stack = []uintptr{record.PC}
+		frame, _ := runtime.CallersFrames(stack).Next()
+		entry.File = frame.File
+		entry.Line = frame.Line
+		entry.FullMessage = formatStack(stack)
+	}
+	entry.Fingerprint = fingerprint(record.Message, stack)
+	if ctx != nil {
+		if ip, referer, userAgent := contextDetails(ctx); ip != "" || referer != "" || userAgent != "" {
+			entry.IPAddress, entry.URLReferer, entry.UserAgent = ip, referer, userAgent
+		}
+	}
+
+	if len(handler.attrs) > 0 || record.NumAttrs() > 0 {
+		entry.Fields = make(map[string]interface{}, len(handler.attrs)+record.NumAttrs())
+		for key, value := range handler.attrs {
+			entry.Fields[key] = value
+		}
+		record.Attrs(func(attr slog.Attr) bool {
+			addSlogAttr(entry.Fields, handler.prefix, attr)
+			return true
+		})
+	}
+
+	return sendToLogger(entry)
+}
+
+// WithAttrs returns a handler adding the attributes to every record
+func (handler *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	newHandler := &SlogHandler{
+		level:  handler.level,
+		attrs:  make(map[string]interface{}, len(handler.attrs)+len(attrs)),
+		prefix: handler.prefix,
+	}
+	for key, value := range handler.attrs {
+		newHandler.attrs[key] = value
+	}
+	for _, attr := range attrs {
+		addSlogAttr(newHandler.attrs, handler.prefix, attr)
+	}
+	return newHandler
+}
+
+// WithGroup returns a handler prefixing the keys of the next attributes with the group name
+func (handler *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return handler
+	}
+	return &SlogHandler{
+		level:  handler.level,
+		attrs:  handler.attrs,
+		prefix: handler.prefix + name + ".",
+	}
+}
+
+// addSlogAttr flattens an attribute in the fields, the keys of the groups are joined with dots
+func addSlogAttr(fields map[string]interface{}, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix += attr.Key + "."
+		}
+		for _, groupAttr := range value.Group() {
+			addSlogAttr(fields, groupPrefix, groupAttr)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	fields[prefix+attr.Key] = value.Any()
+}
+
+// levelFromSlog converts a slog level to our levels
+func levelFromSlog(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError+4:
+		return LevelCritical
+	case level >= slog.LevelError:
+		return LevelError
+	case level >= slog.LevelWarn:
+		return LevelWarning
+	case level >= slog.LevelInfo:
+		return LevelNotice
+	default:
+		return LevelDebug
+	}
+}
+
+// levelToSlog converts one of our levels to a slog level
+func levelToSlog(level int) slog.Level {
+	switch {
+	case level <= LevelCritical:
+		return slog.LevelError + 4
+	case level <= LevelError:
+		return slog.LevelError
+	case level <= LevelWarning:
+		return slog.LevelWarn
+	case level <= LevelNotice:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
+
+// Slog is a destination handing the entries over to a slog.Handler
+type Slog struct {
+	handler slog.Handler
+	metrics *sinkMetrics
+}
+
+// NewSlog will instantiate our logger on top of a slog handler
+// ex : wlog.NewSlog(slog.NewJSONHandler(os.Stderr, nil))
+func NewSlog(handler slog.Handler) *Slog {
+	loggerSlog := new(Slog)
+	loggerSlog.handler = handler
+	loggerSlog.metrics = metricsFor("slog", "")
+	return loggerSlog
+}
+
+// Critical is used for errors that cannot be recovered
+func (logger *Slog) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelCritical, msg, r, 1))
+	WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// Error is used for errors that cannot be recovered but we can still live with them
+func (logger *Slog) Error(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelError, msg, r, 1))
+	WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// NotFound is used when a content or corresponding value was not found
+func (logger *Slog) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	WriteErrorResponse(w, http.StatusNotFound)
+	return nil
+}
+
+// Warning is used for errors that have been recovered
+func (logger *Slog) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelWarning, msg, r, 1))
+	return nil
+}
+
+// Notice is mainly used internally for debugging to console
+func (logger *Slog) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelNotice, msg, r, 1))
+	return nil
+}
+
+// Debug is mainly used internally for debugging to console
+func (logger *Slog) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
+	logger.Send(NewEntry(LevelDebug, msg, r, 1))
+	return nil
+}
+
+// Send converts an entry to a record, the details and fields become attributes
+func (logger *Slog) Send(entry *Entry) error {
+	if entry == nil || !logger.metrics.accept(entry) {
+		return nil
+	}
+
+	ctx := context.Background()
+	level := levelToSlog(entry.Level)
+	if !logger.handler.Enabled(ctx, level) {
+		logger.metrics.filtered(entry.Level)
+		return nil
+	}
+
+	record := slog.NewRecord(entry.Time, level, entry.Message, 0)
+	record.AddAttrs(
+		slog.String("app", entry.App),
+		slog.String("app_group", entry.AppGroup),
+		slog.String("file", entry.File),
+		slog.Int("line", entry.Line),
+	)
+	requestAttrs := [][2]string{
+		{"url", entry.URL},
+		{"ip_address", entry.IPAddress},
+		{"url_referer", entry.URLReferer},
+		{"user_agent", entry.UserAgent},
+	}
+	for _, requestAttr := range requestAttrs {
+		if requestAttr[1] != "" && requestAttr[1] != "unknown" {
+			record.AddAttrs(slog.String(requestAttr[0], requestAttr[1]))
+		}
+	}
+	if entry.Fingerprint != "" {
+		record.AddAttrs(slog.String("fingerprint", entry.Fingerprint))
+	}
+	if len(entry.ErrorChain) > 0 {
+		record.AddAttrs(slog.String("error_chain", strings.Join(entry.ErrorChain, "\ncaused by: ")))
+	}
+	for key, value := range entry.Fields {
+		record.AddAttrs(slog.Any(key, value))
+	}
+
+	return logger.metrics.done(entry.Level, logger.handler.Handle(ctx, record))
+}
+
+// Flush does nothing, the handler decides how the records are written
+func (logger *Slog) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, the handler belongs to the caller
+func (logger *Slog) Close() error {
+	logger.metrics.unregister()
+	return nil
+}
@@ -0,0 +1,54 @@
+//go:build go1.21
+// +build go1.21
+
+package wlog
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestLevelFromSlog(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  int
+	}{
+		{slog.LevelDebug - 4, LevelDebug},
+		{slog.LevelDebug, LevelDebug},
+		{slog.LevelInfo, LevelNotice},
+		{slog.LevelInfo + 2, LevelNotice},
+		{slog.LevelWarn, LevelWarning},
+		{slog.LevelError, LevelError},
+		{slog.LevelError + 2, LevelError},
+		{slog.LevelError + 4, LevelCritical},
+		{slog.LevelError + 8, LevelCritical},
+	}
+	for _, test := range tests {
+		if got := levelFromSlog(test.level); got != test.want {
+			t.Errorf("levelFromSlog(%s) = %d, want %d", test.level, got, test.want)
+		}
+	}
+}
+
+func TestLevelToSlog(t *testing.T) {
+	tests := []struct {
+		level int
+		want  slog.Level
+	}{
+		{LevelCritical, slog.LevelError + 4},
+		{LevelError, slog.LevelError},
+		{LevelWarning, slog.LevelWarn},
+		{LevelNotice, slog.LevelInfo},
+		{LevelDebug, slog.LevelDebug},
+	}
+	for _, test := range tests {
+		got := levelToSlog(test.level)
+		if got != test.want {
+			t.Errorf("levelToSlog(%d) = %s, want %s", test.level, got, test.want)
+		}
+		// the levels survive the round trip
+		if back := levelFromSlog(got); back != test.level {
+			t.Errorf("levelFromSlog(levelToSlog(%d)) = %d", test.level, back)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package wlog
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// logWriter sends each write of the standard log package as an entry
+type logWriter struct {
+	level int
+}
+
+// NewLogWriter returns a writer sending each line written by the standard log package as an entry of the level
+// ex : log.SetFlags(0); log.SetOutput(wlog.NewLogWriter(wlog.LevelWarning))
+func NewLogWriter(level int) io.Writer {
+	return logWriter{level: level}
+}
+
+// Write sends a line as an entry, the caller is the code calling the log package
+func (writer logWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if Logger.destination == nil {
+		// never lose the output of the third party libraries
+		return os.Stderr.Write(p)
+	}
+	// skip logWriter.Write and the log packages
+	sendToLogger(buildEntry(writer.level, msg, nil, logPackagesFrames()+1))
+	return len(p), nil
+}
+
+// logPackagesFrames returns the number of frames of the log packages above the caller of logPackagesFrames
+func logPackagesFrames() int {
+	pcs := make([]uintptr, 32)
+	// skip runtime.Callers, logPackagesFrames and its caller
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for skip := 0; ; skip++ {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "log.") && !strings.HasPrefix(frame.Function, "log/slog.") {
+			return skip
+		}
+		if !more {
+			return 0
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package wlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the kinds of webhook, they do not share the same markdown
+const (
+	WebhookSlack      = "slack"
+	WebhookMattermost = "mattermost"
+)
+
+// WebhookSettings is the struct that is used for configuring the webhook alert hook
+type WebhookSettings struct {
+	Kind      string        // WebhookSlack or WebhookMattermost, default WebhookSlack for the hooks.slack.com urls and WebhookMattermost otherwise
+	Username  string        // overrides the name of the webhook, if the server allows it
+	Channel   string        // overrides the channel of the webhook, if the server allows it
+	IconEmoji string        // ex: ":rotating_light:"
+	Timeout   time.Duration // default 5s
+}
+
+// Webhook posts the alerts to a Slack or Mattermost incoming webhook
+type Webhook struct {
+	url      string
+	settings WebhookSettings
+	client   *http.Client
+}
+
+// NewWebhook will instantiate our alert hook
+// ex : wlog.NewWebhook("https://mattermost.example.com/hooks/xxx", wlog.WebhookSettings{Channel: "alerts"})
+func NewWebhook(hookURL string, settingsOpt ...WebhookSettings) *Webhook {
+	var settings WebhookSettings
+	if len(settingsOpt) > 0 {
+		settings = settingsOpt[0]
+	}
+	if settings.Kind == "" {
+		settings.Kind = WebhookMattermost
+		if parsedURL, err := url.Parse(hookURL); err == nil && strings.HasSuffix(parsedURL.Hostname(), "slack.com") {
+			settings.Kind = WebhookSlack
+		}
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = 5 * time.Second
+	}
+
+	webhook := new(Webhook)
+	webhook.url = hookURL
+	webhook.settings = settings
+	webhook.client = &http.Client{Timeout: settings.Timeout}
+	return webhook
+}
+
+// webhookPayload is the json understood by both Slack and Mattermost
+type webhookPayload struct {
+	Text      string `json:"text"`
+	Username  string `json:"username,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	IconEmoji string `json:"icon_emoji,omitempty"`
+}
+
+// Alert posts an alert to the webhook
+func (hook *Webhook) Alert(alert *Alert) error {
+	payloadJSON, err := json.Marshal(webhookPayload{
+		Text:      formatAlert(alert, hook.settings.Kind),
+		Username:  hook.settings.Username,
+		Channel:   hook.settings.Channel,
+		IconEmoji: hook.settings.IconEmoji,
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := hook.client.Post(hook.url, "application/json", bytes.NewBuffer(payloadJSON))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// formatAlert formats an alert as the markdown of the kind of webhook, slack uses single asterisks for bold
+// ex : **[CRITICAL] myapp (prod)** database is down
+func formatAlert(alert *Alert, kind string) string {
+	bold := "**"
+	if kind == WebhookSlack {
+		bold = "*"
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s[%s] %s", bold, strings.ToUpper(LevelName(alert.Level)), alert.App)
+	if alert.Environment != "" {
+		fmt.Fprintf(&text, " (%s)", alert.Environment)
+	}
+	fmt.Fprintf(&text, "%s %s\n", bold, alert.Message)
+	fmt.Fprintf(&text, "caller: `%s`\n", alert.Caller)
+	if alert.URL != "" {
+		fmt.Fprintf(&text, "url: `%s`\n", alert.URL)
+	}
+	fmt.Fprintf(&text, "fingerprint: `%s`", alert.Fingerprint)
+	if alert.Throttled > 0 {
+		fmt.Fprintf(&text, ", %d similar alerts throttled", alert.Throttled)
+	}
+	return text.String()
+}
@@ -0,0 +1,187 @@
+package wlog
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webediads/adsgolib/wconfig"
+)
+
+// webhookReceiver is an incoming webhook keeping what it was posted
+type webhookReceiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	payloads []webhookPayload
+	status   int
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	receiver := &webhookReceiver{status: http.StatusOK}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("webhook payload: %v", err)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", contentType)
+		}
+		receiver.mutex.Lock()
+		receiver.payloads = append(receiver.payloads, payload)
+		status := receiver.status
+		receiver.mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	return receiver
+}
+
+func (receiver *webhookReceiver) received() []webhookPayload {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return append([]webhookPayload(nil), receiver.payloads...)
+}
+
+func TestWebhookKind(t *testing.T) {
+	tests := []struct {
+		url      string
+		settings WebhookSettings
+		want     string
+	}{
+		{"https://hooks.slack.com/services/T0/B0/xxx", WebhookSettings{}, WebhookSlack},
+		{"https://mattermost.example.com/hooks/xxx", WebhookSettings{}, WebhookMattermost},
+		{"https://proxy.example.com/slack", WebhookSettings{Kind: WebhookSlack}, WebhookSlack},
+	}
+	for _, test := range tests {
+		if got := NewWebhook(test.url, test.settings).settings.Kind; got != test.want {
+			t.Errorf("NewWebhook(%q) kind = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestFormatAlert(t *testing.T) {
+	alert := &Alert{
+		App:         "myapp",
+		Environment: "prod",
+		Level:       LevelCritical,
+		Message:     "database is down",
+		Caller:      "main.go:12",
+		URL:         "/home",
+		Fingerprint: "abc",
+		Throttled:   2,
+	}
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{WebhookSlack, "*[CRITICAL] myapp (prod)* database is down\ncaller: `main.go:12`\nurl: `/home`\nfingerprint: `abc`, 2 similar alerts throttled"},
+		{WebhookMattermost, "**[CRITICAL] myapp (prod)** database is down\ncaller: `main.go:12`\nurl: `/home`\nfingerprint: `abc`, 2 similar alerts throttled"},
+	}
+	for _, test := range tests {
+		if got := formatAlert(alert, test.kind); got != test.want {
+			t.Errorf("formatAlert(%s) = %q, want %q", test.kind, got, test.want)
+		}
+	}
+}
+
+func TestWebhookAlert(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	defer receiver.Close()
+	alert := &Alert{
+		App:         "myapp",
+		Environment: "prod",
+		Level:       LevelCritical,
+		Message:     "database is down",
+		Caller:      "main.go:12",
+		URL:         "/home",
+		Fingerprint: "abc",
+	}
+
+	tests := []struct {
+		kind string
+		bold string
+	}{
+		{WebhookSlack, "*[CRITICAL] myapp (prod)* "},
+		{WebhookMattermost, "**[CRITICAL] myapp (prod)** "},
+	}
+	for _, test := range tests {
+		t.Run(test.kind, func(t *testing.T) {
+			receiver.mutex.Lock()
+			receiver.payloads = nil
+			receiver.mutex.Unlock()
+			hook := NewWebhook(receiver.URL, WebhookSettings{Kind: test.kind, Username: "alerts", Channel: "ops", IconEmoji: ":rotating_light:"})
+			if err := hook.Alert(alert); err != nil {
+				t.Fatal(err)
+			}
+
+			payloads := receiver.received()
+			if len(payloads) != 1 {
+				t.Fatalf("%d payloads posted, want 1", len(payloads))
+			}
+			payload := payloads[0]
+			if payload.Username != "alerts" || payload.Channel != "ops" || payload.IconEmoji != ":rotating_light:" {
+				t.Errorf("payload = %+v, want the settings of the webhook", payload)
+			}
+			for _, want := range []string{test.bold + "database is down", "caller: `main.go:12`", "url: `/home`", "fingerprint: `abc`"} {
+				if !strings.Contains(payload.Text, want) {
+					t.Errorf("text = %q, want %q in it", payload.Text, want)
+				}
+			}
+		})
+	}
+
+	receiver.mutex.Lock()
+	receiver.status = http.StatusInternalServerError
+	receiver.mutex.Unlock()
+	if err := NewWebhook(receiver.URL).Alert(alert); err == nil {
+		t.Error("Alert() = nil, want the status of the webhook")
+	}
+}
+
+func TestAlerterThrottle(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	defer receiver.Close()
+	wconfig.Config.SetEnvironment("prod")
+	defer wconfig.Config.SetEnvironment("")
+
+	// the counters of a name are shared, ex: with go test -count
+	before := Stats()["alerts throttle test"].Levels[LevelName(LevelCritical)]
+	alerter := NewAlerter(NewConsole(ConsoleSettings{Writer: ioutil.Discard}), []AlertHook{NewWebhook(receiver.URL)},
+		AlertSettings{Name: "alerts throttle test", Throttle: time.Hour})
+	defer alerter.Close()
+
+	for i := 0; i < 2; i++ {
+		alerter.Send(&Entry{
+			Time:        time.Now(),
+			App:         "myapp",
+			Level:       LevelCritical,
+			Message:     "database is down",
+			Fingerprint: "abc",
+			File:        "main.go",
+			Line:        12,
+			URL:         "/home",
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := alerter.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := receiver.received()
+	if len(payloads) != 1 {
+		t.Fatalf("%d alerts posted, want 1", len(payloads))
+	}
+	if want := "**[CRITICAL] myapp (prod)** database is down"; !strings.HasPrefix(payloads[0].Text, want) {
+		t.Errorf("text = %q, want %q first", payloads[0].Text, want)
+	}
+	after := Stats()["alerts throttle test"].Levels[LevelName(LevelCritical)]
+	if after.Emitted-before.Emitted != 1 || after.Throttled-before.Throttled != 1 || after.Filtered != before.Filtered {
+		t.Errorf("stats = %+v then %+v, want 1 more emitted and 1 more throttled", before, after)
+	}
+}
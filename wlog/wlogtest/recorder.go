@@ -0,0 +1,179 @@
+// Package wlogtest provides a wlog destination recording the entries in memory, so that tests can assert on what is logged
+package wlogtest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// Recorder is a wlog destination keeping the entries in memory, it is safe for concurrent use
+type Recorder struct {
+	mutex   sync.Mutex
+	entries []wlog.Entry
+}
+
+// NewRecorder will instantiate our recorder
+func NewRecorder() *Recorder {
+	return new(Recorder)
+}
+
+// Install sets a new recorder as the wlog destination and returns it along with the function restoring the previous destination
+// ex : recorder, restore := wlogtest.Install(); defer restore()
+func Install() (*Recorder, func()) {
+	recorder := NewRecorder()
+	previousDestination := wlog.SetDestination(recorder)
+	return recorder, func() {
+		wlog.SetDestination(previousDestination)
+	}
+}
+
+// Critical is used for errors that cannot be recovered
+func (recorder *Recorder) Critical(msg string, w http.ResponseWriter, r *http.Request) error {
+	recorder.Send(wlog.NewEntry(wlog.LevelCritical, msg, r, 1))
+	wlog.WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// Error is used for errors that cannot be recovered but we can still live with them
+func (recorder *Recorder) Error(msg string, w http.ResponseWriter, r *http.Request) error {
+	recorder.Send(wlog.NewEntry(wlog.LevelError, msg, r, 1))
+	wlog.WriteErrorResponse(w, http.StatusInternalServerError)
+	return nil
+}
+
+// NotFound is used when a content or corresponding value was not found
+func (recorder *Recorder) NotFound(msg string, w http.ResponseWriter, r *http.Request) error {
+	recorder.Send(wlog.NewEntry(wlog.LevelDebug, msg, r, 1))
+	wlog.WriteErrorResponse(w, http.StatusNotFound)
+	return nil
+}
+
+// Warning is used for errors that have been recovered
+func (recorder *Recorder) Warning(msg string, w http.ResponseWriter, r *http.Request) error {
+	recorder.Send(wlog.NewEntry(wlog.LevelWarning, msg, r, 1))
+	return nil
+}
+
+// Notice is mainly used internally for debugging to console
+func (recorder *Recorder) Notice(msg string, w http.ResponseWriter, r *http.Request) error {
+	recorder.Send(wlog.NewEntry(wlog.LevelNotice, msg, r, 1))
+	return nil
+}
+
+// Debug is mainly used internally for debugging to console
+func (recorder *Recorder) Debug(msg string, w http.ResponseWriter, r *http.Request) error {
+	recorder.Send(wlog.NewEntry(wlog.LevelDebug, msg, r, 1))
+	return nil
+}
+
+// Send records a copy of an entry
+func (recorder *Recorder) Send(entry *wlog.Entry) error {
+	if entry == nil {
+		return nil
+	}
+
+	recorded := *entry
+	if entry.Fields != nil {
+		recorded.Fields = make(map[string]interface{}, len(entry.Fields))
+		for key, value := range entry.Fields {
+			recorded.Fields[key] = value
+		}
+	}
+	recorded.ErrorChain = append([]string(nil), entry.ErrorChain...)
+
+	recorder.mutex.Lock()
+	recorder.entries = append(recorder.entries, recorded)
+	recorder.mutex.Unlock()
+	return nil
+}
+
+// Flush does nothing, the entries are recorded synchronously
+func (recorder *Recorder) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, the recorder can still be read
+func (recorder *Recorder) Close() error {
+	return nil
+}
+
+// Entries returns the recorded entries, oldest first
+func (recorder *Recorder) Entries() []wlog.Entry {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]wlog.Entry(nil), recorder.entries...)
+}
+
+// Find returns the recorded entries of a level whose message contains substring
+func (recorder *Recorder) Find(level int, substring string) []wlog.Entry {
+	var found []wlog.Entry
+	for _, entry := range recorder.Entries() {
+		if entry.Level == level && strings.Contains(entry.Message, substring) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// Reset forgets the recorded entries
+func (recorder *Recorder) Reset() {
+	recorder.mutex.Lock()
+	recorder.entries = nil
+	recorder.mutex.Unlock()
+}
+
+// AssertLogged fails the test if no entry of the level contains substring
+func (recorder *Recorder) AssertLogged(t testing.TB, level int, substring string) {
+	t.Helper()
+	if len(recorder.Find(level, substring)) == 0 {
+		t.Errorf("no %s entry containing %q was logged, got:\n%s", wlog.LevelName(level), substring, recorder.summary())
+	}
+}
+
+// AssertNotLogged fails the test if an entry of the level contains substring
+func (recorder *Recorder) AssertNotLogged(t testing.TB, level int, substring string) {
+	t.Helper()
+	if len(recorder.Find(level, substring)) > 0 {
+		t.Errorf("a %s entry containing %q was logged, got:\n%s", wlog.LevelName(level), substring, recorder.summary())
+	}
+}
+
+// summary lists the recorded entries for the failure messages
+func (recorder *Recorder) summary() string {
+	entries := recorder.Entries()
+	if len(entries) == 0 {
+		return "\t(nothing)"
+	}
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = "\t" + wlog.LevelName(entry.Level) + ": " + entry.Message
+	}
+	return strings.Join(lines, "\n")
+}
+
+// AssertLogged fails the test if the installed recorder has no entry of the level containing substring
+func AssertLogged(t testing.TB, level int, substring string) {
+	t.Helper()
+	installed(t).AssertLogged(t, level, substring)
+}
+
+// AssertNotLogged fails the test if the installed recorder has an entry of the level containing substring
+func AssertNotLogged(t testing.TB, level int, substring string) {
+	t.Helper()
+	installed(t).AssertNotLogged(t, level, substring)
+}
+
+// installed returns the recorder set as the wlog destination
+func installed(t testing.TB) *Recorder {
+	t.Helper()
+	recorder, ok := wlog.GetLogger().(*Recorder)
+	if !ok {
+		t.Fatalf("the wlog destination is not a recorder, wlogtest.Install must be called first")
+	}
+	return recorder
+}
@@ -0,0 +1,184 @@
+package wlogtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// fakeTB records the failures of the assertions instead of failing the test
+type fakeTB struct {
+	testing.TB
+	failures []string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Errorf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+// Fatalf stops the goroutine like testing.T does, the assertion must run in its own goroutine
+func (tb *fakeTB) Fatalf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+	runtime.Goexit()
+}
+
+// assert runs an assertion with a fakeTB and returns its failures
+func assert(assertion func(tb testing.TB)) []string {
+	tb := new(fakeTB)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assertion(tb)
+	}()
+	<-done
+	return tb.failures
+}
+
+func TestInstall(t *testing.T) {
+	previous := NewRecorder()
+	restorePrevious := wlog.SetDestination(previous)
+	defer wlog.SetDestination(restorePrevious)
+
+	recorder, restore := Install()
+	if wlog.GetLogger() != recorder {
+		t.Fatal("Install did not set the recorder as the destination")
+	}
+	wlog.GetLogger().Warning("cache is cold", nil, nil)
+	restore()
+
+	if wlog.GetLogger() != previous {
+		t.Fatal("restore did not set the previous destination back")
+	}
+	wlog.GetLogger().Warning("after restore", nil, nil)
+	if entries := recorder.Entries(); len(entries) != 1 || entries[0].Message != "cache is cold" {
+		t.Errorf("the recorder has %+v, want the entry sent while installed only", entries)
+	}
+	if entries := previous.Entries(); len(entries) != 1 || entries[0].Message != "after restore" {
+		t.Errorf("the previous destination has %+v, want the entry sent after restore only", entries)
+	}
+}
+
+func TestFind(t *testing.T) {
+	recorder, restore := Install()
+	defer restore()
+
+	response := httptest.NewRecorder()
+	wlog.GetLogger().Error("db main is down", response, nil)
+	wlog.GetLogger().Warning("db main is slow", nil, nil)
+	wlog.GetLogger().Warning("cache is cold", nil, nil)
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("Error responded %d, want %d", response.Code, http.StatusInternalServerError)
+	}
+
+	tests := []struct {
+		name      string
+		level     int
+		substring string
+		want      []string
+	}{
+		{"level and substring", wlog.LevelWarning, "db main", []string{"db main is slow"}},
+		{"several entries", wlog.LevelWarning, "is", []string{"db main is slow", "cache is cold"}},
+		{"empty substring", wlog.LevelError, "", []string{"db main is down"}},
+		{"other level", wlog.LevelCritical, "db main", nil},
+		{"no match", wlog.LevelWarning, "kafka", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var messages []string
+			for _, entry := range recorder.Find(test.level, test.substring) {
+				messages = append(messages, entry.Message)
+			}
+			if strings.Join(messages, "|") != strings.Join(test.want, "|") {
+				t.Errorf("Find(%d, %q) = %q, want %q", test.level, test.substring, messages, test.want)
+			}
+		})
+	}
+
+	recorder.Reset()
+	if entries := recorder.Entries(); len(entries) != 0 {
+		t.Errorf("Reset kept %d entries", len(entries))
+	}
+}
+
+func TestAssertLogged(t *testing.T) {
+	_, restore := Install()
+	defer restore()
+	wlog.GetLogger().Warning("db main is slow", nil, nil)
+
+	tests := []struct {
+		name      string
+		assert    func(t testing.TB, level int, substring string)
+		level     int
+		substring string
+		fails     bool
+	}{
+		{"logged", AssertLogged, wlog.LevelWarning, "is slow", false},
+		{"logged at another level", AssertLogged, wlog.LevelError, "is slow", true},
+		{"not logged", AssertLogged, wlog.LevelWarning, "is down", true},
+		{"not logged as expected", AssertNotLogged, wlog.LevelWarning, "is down", false},
+		{"unexpectedly logged", AssertNotLogged, wlog.LevelWarning, "is slow", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failures := assert(func(tb testing.TB) {
+				test.assert(tb, test.level, test.substring)
+			})
+			if failed := len(failures) > 0; failed != test.fails {
+				t.Errorf("the assertion failed: %v, want %v (%q)", failed, test.fails, failures)
+			}
+			if test.fails && len(failures) > 0 && !strings.Contains(failures[0], "warning: db main is slow") {
+				t.Errorf("the failure %q does not list the recorded entries", failures[0])
+			}
+		})
+	}
+}
+
+func TestAssertLoggedNotInstalled(t *testing.T) {
+	previous := wlog.SetDestination(wlog.NewConsole())
+	defer wlog.SetDestination(previous)
+
+	failures := assert(func(tb testing.TB) {
+		AssertLogged(tb, wlog.LevelWarning, "anything")
+	})
+	if len(failures) != 1 || !strings.Contains(failures[0], "wlogtest.Install must be called first") {
+		t.Errorf("AssertLogged without a recorder reported %q", failures)
+	}
+}
+
+func TestConcurrentSend(t *testing.T) {
+	recorder, restore := Install()
+	defer restore()
+
+	const senders, entriesPerSender = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < entriesPerSender; j++ {
+				wlog.GetLogger().Warning(fmt.Sprintf("sender %d entry %d", i, j), nil, nil)
+			}
+		}(i)
+	}
+	// the entries are read while they are being recorded
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < entriesPerSender; j++ {
+			recorder.Find(wlog.LevelWarning, "sender")
+		}
+	}()
+	wg.Wait()
+
+	if entries := recorder.Entries(); len(entries) != senders*entriesPerSender {
+		t.Errorf("%d entries were recorded, want %d", len(entries), senders*entriesPerSender)
+	}
+}
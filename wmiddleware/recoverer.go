@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"runtime"
 	"runtime/debug"
+	"strings"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/webediads/adsgolib/wconfig"
@@ -32,7 +34,13 @@ func Recoverer() func(next http.Handler) http.Handler {
 						w.Write([]byte(fmt.Sprintf("Panic: %+v", rvr)))
 					}
 
-					wlog.GetLogger().Critical(fmt.Sprintf("Panic: %+v", rvr), w, r)
+					var panicErr error
+					if rvrErr, ok := rvr.(error); ok {
+						panicErr = fmt.Errorf("Panic: %w", rvrErr)
+					} else {
+						panicErr = fmt.Errorf("Panic: %+v", rvr)
+					}
+					wlog.LogError(wlog.LevelCritical, panicErr, wlog.Request(r), wlog.Response(w), wlog.Skip(panicFrames()))
 
 					logEntry := middleware.GetLogEntry(r)
 					if logEntry != nil {
@@ -53,6 +61,26 @@ func Recoverer() func(next http.Handler) http.Handler {
 	}
 }
 
+// panicFrames returns the number of frames between the deferred function calling it and the code that panicked
+func panicFrames() int {
+	pcs := make([]uintptr, 32)
+	// skip runtime.Callers, panicFrames and the deferred function
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	inRuntime := false
+	for skip := 1; ; skip++ {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "runtime.") {
+			inRuntime = true
+		} else if inRuntime {
+			return skip
+		}
+		if !more {
+			return 0
+		}
+	}
+}
+
 func find(slice []string, val string) (int, bool) {
 	for i, item := range slice {
 		if item == val {
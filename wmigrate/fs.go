@@ -0,0 +1,30 @@
+//go:build go1.16
+// +build go1.16
+
+package wmigrate
+
+import (
+	"io/fs"
+	"path"
+)
+
+// LoadFS reads the migrations of a directory of a file system, ex: the one embedded with go:embed
+// ex : //go:embed migrations/*.sql
+//
+//	var migrationFiles embed.FS
+//	migrations, err := wmigrate.LoadFS(migrationFiles, "migrations")
+func LoadFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return parseMigrations(names, func(name string) ([]byte, error) {
+		return fs.ReadFile(fsys, path.Join(dir, name))
+	})
+}
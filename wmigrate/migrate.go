@@ -0,0 +1,353 @@
+package wmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/webediads/adsgolib/wconnectors"
+	"github.com/webediads/adsgolib/wlog"
+)
+
+// Latest is the target of Migrate applying every migration
+const Latest int64 = -1
+
+// mysql error returned when the migrations table does not exist yet
+const mysqlErrNoSuchTable = 1146
+
+// Settings is the struct that is used for configuring a Migrator
+type Settings struct {
+	Table       string        // table of the applied versions, default schema_migrations
+	LockName    string        // name of the GET_LOCK taken while migrating, default wmigrate.<table>
+	LockTimeout time.Duration // how long to wait for another migration to finish, default 1m
+	DryRun      bool          // Migrate returns the steps it would run without running them
+}
+
+// Migrator applies the migrations to a connection registered with wconnectors.RegisterDb
+type Migrator struct {
+	dbName     string
+	migrations []Migration
+	settings   Settings
+}
+
+// Step is a migration that was run, or would be run in dry run
+type Step struct {
+	Version    int64
+	Name       string
+	Down       bool // the migration is rolled back
+	Statements []string
+}
+
+// Status is the state of a migration
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Missing   bool // applied but not among the migrations, ex: it was applied by a newer version of the service
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// New will instantiate a migrator of the migrations for a registered mysql connection
+// ex : migrator, err := wmigrate.New("main", migrations, wmigrate.Settings{DryRun: true})
+func New(dbName string, migrations []Migration, settingsOpt ...Settings) (*Migrator, error) {
+	var settings Settings
+	if len(settingsOpt) > 0 {
+		settings = settingsOpt[0]
+	}
+	if settings.Table == "" {
+		settings.Table = "schema_migrations"
+	}
+	if !tableName.MatchString(settings.Table) {
+		return nil, fmt.Errorf("wmigrate: invalid table %q", settings.Table)
+	}
+	if settings.LockName == "" {
+		settings.LockName = "wmigrate." + settings.Table
+	}
+	if settings.LockTimeout <= 0 {
+		settings.LockTimeout = time.Minute
+	}
+	if driver := wconnectors.DbDriver(dbName); driver != "" && driver != wconnectors.DbDriverMySQL {
+		return nil, fmt.Errorf("wmigrate: the migrations take a GET_LOCK of mysql, the %s driver of db %s is not supported", driver, dbName)
+	}
+
+	versions := make(map[int64]bool, len(migrations))
+	for _, migration := range migrations {
+		if migration.Version < 0 {
+			return nil, fmt.Errorf("wmigrate: invalid version %d", migration.Version)
+		}
+		if versions[migration.Version] {
+			return nil, fmt.Errorf("wmigrate: version %d is used twice", migration.Version)
+		}
+		versions[migration.Version] = true
+	}
+	migrations = append([]Migration(nil), migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{dbName: dbName, migrations: migrations, settings: settings}, nil
+}
+
+// Up applies the pending migrations
+func (migrator *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return migrator.Migrate(ctx, Latest)
+}
+
+// Down rolls back the last applied migration
+func (migrator *Migrator) Down(ctx context.Context) ([]Step, error) {
+	return migrator.migrate(ctx, migrator.rollbackLast)
+}
+
+// Migrate rolls back the applied migrations above the target version then applies the pending ones up to it,
+// Latest applies them all. A lock is held meanwhile so that the instances of a service starting together
+// do not run the migrations concurrently.
+// MySQL commits the schema changes right away: if a statement fails, the previous ones of the migration stay applied
+// and the version is not recorded, the migration has to be fixed by hand
+func (migrator *Migrator) Migrate(ctx context.Context, target int64) ([]Step, error) {
+	return migrator.migrate(ctx, func(applied map[int64]Status) ([]Step, error) {
+		return migrator.plan(applied, target)
+	})
+}
+
+// migrate runs the steps planned from the applied versions
+func (migrator *Migrator) migrate(ctx context.Context, plan func(applied map[int64]Status) ([]Step, error)) ([]Step, error) {
+	db, err := wconnectors.DbE(migrator.dbName)
+	if err != nil {
+		return nil, err
+	}
+	// GET_LOCK belongs to the session, every statement goes through the same connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("wmigrate: %w", err)
+	}
+	defer conn.Close()
+
+	if !migrator.settings.DryRun {
+		if err := migrator.lock(ctx, conn); err != nil {
+			return nil, err
+		}
+		defer migrator.unlock(conn)
+		if err := migrator.createTable(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	applied, err := migrator.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := plan(applied)
+	if err != nil || migrator.settings.DryRun {
+		return steps, err
+	}
+
+	for i, step := range steps {
+		if err := migrator.run(ctx, conn, step); err != nil {
+			return steps[:i], err
+		}
+	}
+	return steps, nil
+}
+
+// Status returns the state of the migrations, the applied versions that are not among them included
+func (migrator *Migrator) Status(ctx context.Context) ([]Status, error) {
+	db, err := wconnectors.DbE(migrator.dbName)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("wmigrate: %w", err)
+	}
+	defer conn.Close()
+
+	applied, err := migrator.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(migrator.migrations))
+	for _, migration := range migrator.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if appliedStatus, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = appliedStatus.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, appliedStatus := range applied {
+		statuses = append(statuses, appliedStatus)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// plan returns the steps reaching the target version
+func (migrator *Migrator) plan(applied map[int64]Status, target int64) ([]Step, error) {
+	if target == Latest {
+		target = math.MaxInt64
+	}
+	steps, err := migrator.rollbacks(applied, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range migrator.migrations {
+		if migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			steps = append(steps, Step{Version: migration.Version, Name: migration.Name, Statements: splitStatements(migration.Up)})
+		}
+	}
+	return steps, nil
+}
+
+// rollbacks returns the steps rolling back the applied versions above the target version, the last one first
+func (migrator *Migrator) rollbacks(applied map[int64]Status, target int64) ([]Step, error) {
+	var steps []Step
+	var rollbacks []int64
+	for version := range applied {
+		if version > target {
+			rollbacks = append(rollbacks, version)
+		}
+	}
+	sort.Slice(rollbacks, func(i, j int) bool {
+		return rollbacks[i] > rollbacks[j]
+	})
+	for _, version := range rollbacks {
+		migration, ok := migrator.migration(version)
+		if !ok {
+			return nil, fmt.Errorf("wmigrate: version %d cannot be rolled back, it is not among the migrations", version)
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("wmigrate: version %d cannot be rolled back, it has no down migration", version)
+		}
+		steps = append(steps, Step{Version: version, Name: migration.Name, Down: true, Statements: splitStatements(migration.Down)})
+	}
+	return steps, nil
+}
+
+// rollbackLast returns the step rolling back the last applied version
+func (migrator *Migrator) rollbackLast(applied map[int64]Status) ([]Step, error) {
+	if len(applied) == 0 {
+		return nil, nil
+	}
+	var last int64
+	for version := range applied {
+		if version > last {
+			last = version
+		}
+	}
+	return migrator.rollbacks(applied, last-1)
+}
+
+// migration returns the migration of a version
+func (migrator *Migrator) migration(version int64) (Migration, bool) {
+	for _, migration := range migrator.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// run runs the statements of a step and records it
+func (migrator *Migrator) run(ctx context.Context, conn *sql.Conn, step Step) error {
+	start := time.Now()
+	for i, statement := range step.Statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("wmigrate: %s, statement %d: %w", step, i+1, err)
+		}
+	}
+
+	var err error
+	if step.Down {
+		_, err = conn.ExecContext(ctx, "DELETE FROM `"+migrator.settings.Table+"` WHERE version = ?", step.Version)
+	} else {
+		_, err = conn.ExecContext(ctx, "INSERT INTO `"+migrator.settings.Table+"` (version, name, applied_at) VALUES (?, ?, ?)", step.Version, step.Name, time.Now().UTC())
+	}
+	if err != nil {
+		return fmt.Errorf("wmigrate: %s was run but could not be recorded: %w", step, err)
+	}
+
+	wlog.Log(wlog.LevelNotice, fmt.Sprintf("db %s: migration %s (%s)", migrator.dbName, step, time.Since(start).Round(time.Millisecond)),
+		wlog.Field("db", migrator.dbName), wlog.Field("version", step.Version))
+	return nil
+}
+
+// String describes the step, ex: "0002_add_email up"
+func (step Step) String() string {
+	direction := "up"
+	if step.Down {
+		direction = "down"
+	}
+	return fmt.Sprintf("%04d_%s %s", step.Version, step.Name, direction)
+}
+
+// lock takes the migration lock of the server, it waits for the migrations of another instance to finish
+func (migrator *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	var locked sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrator.settings.LockName, int(migrator.settings.LockTimeout/time.Second)).Scan(&locked)
+	if err != nil {
+		return fmt.Errorf("wmigrate: lock: %w", err)
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return fmt.Errorf("wmigrate: lock %q is held by another migration since more than %s", migrator.settings.LockName, migrator.settings.LockTimeout)
+	}
+	return nil
+}
+
+// unlock releases the migration lock
+func (migrator *Migrator) unlock(conn *sql.Conn) {
+	if _, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrator.settings.LockName); err != nil {
+		wlog.LogError(wlog.LevelWarning, wlog.Wrap(err, "wmigrate: unlock"), wlog.Field("db", migrator.dbName))
+	}
+}
+
+// createTable creates the table of the applied versions
+func (migrator *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `"+migrator.settings.Table+"` ("+
+		"version BIGINT NOT NULL PRIMARY KEY, "+
+		"name VARCHAR(255) NOT NULL, "+
+		"applied_at DATETIME NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("wmigrate: %w", err)
+	}
+	return nil
+}
+
+// applied returns the applied versions, none if the table was not created yet
+func (migrator *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]Status, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, applied_at FROM `"+migrator.settings.Table+"`")
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrNoSuchTable {
+			return map[int64]Status{}, nil
+		}
+		return nil, fmt.Errorf("wmigrate: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]Status)
+	for rows.Next() {
+		status := Status{Applied: true, Missing: true}
+		if err := rows.Scan(&status.Version, &status.Name, &status.AppliedAt); err != nil {
+			return nil, fmt.Errorf("wmigrate: %w", err)
+		}
+		applied[status.Version] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("wmigrate: %w", err)
+	}
+	return applied, nil
+}
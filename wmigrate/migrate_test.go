@@ -0,0 +1,129 @@
+package wmigrate
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestMigrator(t *testing.T) *Migrator {
+	migrator, err := New("wmigrate_test", []Migration{
+		{Version: 3, Name: "add_index", Up: "CREATE INDEX ad_name ON ad (name)"},
+		{Version: 0, Name: "init", Up: "CREATE TABLE ad (id INT)", Down: "DROP TABLE ad"},
+		{Version: 2, Name: "add_name", Up: "ALTER TABLE ad ADD name TEXT", Down: "ALTER TABLE ad DROP name"},
+		{Version: 1, Name: "add_owner", Up: "CREATE TABLE owner (id INT); ALTER TABLE ad ADD owner INT", Down: "DROP TABLE owner"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+func appliedVersions(versions ...int64) map[int64]Status {
+	applied := make(map[int64]Status, len(versions))
+	for _, version := range versions {
+		applied[version] = Status{Version: version, Applied: true}
+	}
+	return applied
+}
+
+// stepVersions returns the steps as "+version" for the ups and "-version" for the downs
+func stepVersions(steps []Step) []string {
+	var versions []string
+	for _, step := range steps {
+		sign := "+"
+		if step.Down {
+			sign = "-"
+		}
+		versions = append(versions, sign+strconv.FormatInt(step.Version, 10))
+	}
+	return versions
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		applied map[int64]Status
+		target  int64
+		want    []string
+		wantErr string
+	}{
+		{"nothing applied", appliedVersions(), Latest, []string{"+0", "+1", "+2", "+3"}, ""},
+		{"up to a target", appliedVersions(), 1, []string{"+0", "+1"}, ""},
+		{"pending steps", appliedVersions(0, 2), Latest, []string{"+1", "+3"}, ""},
+		{"pending steps up to a target", appliedVersions(0), 2, []string{"+1", "+2"}, ""},
+		{"up to date", appliedVersions(0, 1, 2, 3), Latest, nil, ""},
+		{"rollbacks newest first", appliedVersions(0, 1, 2), 0, []string{"-2", "-1"}, ""},
+		{"rollbacks then ups", appliedVersions(0, 2), 1, []string{"-2", "+1"}, ""},
+		{"no down migration", appliedVersions(0, 1, 2, 3), 1, nil, "version 3 cannot be rolled back, it has no down migration"},
+		{"missing version", appliedVersions(0, 1, 2, 5), 2, nil, "version 5 cannot be rolled back, it is not among the migrations"},
+		{"missing version kept below the target", appliedVersions(0, 5), 7, []string{"+1", "+2", "+3"}, ""},
+	}
+	migrator := newTestMigrator(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			steps, err := migrator.plan(test.applied, test.target)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("plan() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stepVersions(steps); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("plan() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlanSteps(t *testing.T) {
+	migrator := newTestMigrator(t)
+	steps, err := migrator.plan(appliedVersions(0, 2), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Step{
+		{Version: 2, Name: "add_name", Down: true, Statements: []string{"ALTER TABLE ad DROP name"}},
+		{Version: 1, Name: "add_owner", Statements: []string{"CREATE TABLE owner (id INT)", "ALTER TABLE ad ADD owner INT"}},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("plan() = %+v, want %+v", steps, want)
+	}
+}
+
+func TestRollbackLast(t *testing.T) {
+	tests := []struct {
+		name    string
+		applied map[int64]Status
+		want    []string
+		wantErr string
+	}{
+		{"nothing applied", appliedVersions(), nil, ""},
+		{"only version 0", appliedVersions(0), []string{"-0"}, ""},
+		{"last one only", appliedVersions(0, 1, 2), []string{"-2"}, ""},
+		{"no down migration", appliedVersions(0, 3), nil, "version 3 cannot be rolled back, it has no down migration"},
+		{"missing version", appliedVersions(0, 6), nil, "version 6 cannot be rolled back, it is not among the migrations"},
+	}
+	migrator := newTestMigrator(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			steps, err := migrator.rollbackLast(test.applied)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("rollbackLast() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stepVersions(steps); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("rollbackLast() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package wmigrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration is a numbered schema change, Down is empty if it cannot be rolled back
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrationFile matches the names of the migration files, ex: 0001_create_users.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_?([^.]*)\.(up|down)\.sql$`)
+
+// LoadDir reads the migrations of a directory, the files are named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// the other files are ignored
+// ex : migrations, err := wmigrate.LoadDir("migrations")
+func LoadDir(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	return parseMigrations(names, func(name string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(dir, name))
+	})
+}
+
+// parseMigrations builds the migrations of the files, sorted by version
+func parseMigrations(names []string, read func(name string) ([]byte, error)) ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+	for _, name := range names {
+		matches := migrationFile.FindStringSubmatch(name)
+		if matches == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wmigrate: %s: invalid version", name)
+		}
+		content, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		} else if migration.Name != matches[2] {
+			return nil, fmt.Errorf("wmigrate: version %d is used by %q and %q", version, migration.Name, matches[2])
+		}
+		sql := strings.TrimSpace(string(content))
+		if matches[3] == "up" {
+			migration.Up = sql
+		} else {
+			migration.Down = sql
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("wmigrate: version %d has no up migration", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// splitStatements splits a migration into its statements, the driver runs one statement at a time.
+// The semicolons of the strings, quoted identifiers and comments are kept, DELIMITER is not supported
+func splitStatements(sql string) []string {
+	var statements []string
+	var quote byte
+	start := 0
+	// whether the current statement has more than spaces and comments
+	hasCode := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			hasCode = true
+		case c == '#' || (c == '-' && strings.HasPrefix(sql[i:], "-- ")):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(sql)
+			}
+		case c == ';':
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(sql[start:i]))
+			}
+			start = i + 1
+			hasCode = false
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			hasCode = true
+		}
+	}
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(sql[start:]))
+	}
+	return statements
+}
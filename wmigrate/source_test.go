@@ -0,0 +1,84 @@
+package wmigrate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"one without semicolon", "CREATE TABLE ad (id INT)", []string{"CREATE TABLE ad (id INT)"}},
+		{"several", "CREATE TABLE ad (id INT);\n\nINSERT INTO ad VALUES (1);\n", []string{"CREATE TABLE ad (id INT)", "INSERT INTO ad VALUES (1)"}},
+		{"semicolons in strings", "INSERT INTO ad (name) VALUES ('a;b'), (\"c;d\"), ('it\\'s;');", []string{"INSERT INTO ad (name) VALUES ('a;b'), (\"c;d\"), ('it\\'s;')"}},
+		{"semicolons in identifiers", "SELECT `a;b` FROM ad;", []string{"SELECT `a;b` FROM ad"}},
+		{"semicolons in comments", "-- drop; this\nSELECT 1; # and; this\n/* or;\nthis */ SELECT 2;", []string{"-- drop; this\nSELECT 1", "# and; this\n/* or;\nthis */ SELECT 2"}},
+		{"only comments", "SELECT 1;\n-- the end;\n/* really */;", []string{"SELECT 1"}},
+		{"empty statements", ";; SELECT 1;;", []string{"SELECT 1"}},
+		{"minus is not a comment", "SELECT 2--1;", []string{"SELECT 2--1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := splitStatements(test.sql); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", test.sql, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+	files := map[string]string{
+		"0002_add_name.up.sql":      "ALTER TABLE ad ADD name TEXT;\n",
+		"0002_add_name.down.sql":    "ALTER TABLE ad DROP name;",
+		"0001_init.up.sql":          "CREATE TABLE ad (id INT);",
+		"0003.up.sql":               "CREATE INDEX ad_name ON ad (name);",
+		"0002_other.up.sql":         "SELECT 1;",
+		"0004_no_up.down.sql":       "SELECT 1;",
+		"README.md":                 "not a migration",
+		"0005_add_owner.up.sql.bak": "not a migration",
+	}
+	read := func(name string) ([]byte, error) {
+		return []byte(files[name]), nil
+	}
+	tests := []struct {
+		name    string
+		names   []string
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name:  "sorted by version",
+			names: []string{"0002_add_name.up.sql", "0002_add_name.down.sql", "0001_init.up.sql", "0003.up.sql", "README.md", "0005_add_owner.up.sql.bak"},
+			want: []Migration{
+				{Version: 1, Name: "init", Up: "CREATE TABLE ad (id INT);"},
+				{Version: 2, Name: "add_name", Up: "ALTER TABLE ad ADD name TEXT;", Down: "ALTER TABLE ad DROP name;"},
+				{Version: 3, Name: "", Up: "CREATE INDEX ad_name ON ad (name);"},
+			},
+		},
+		{name: "no files", names: nil, want: []Migration{}},
+		{name: "version used twice", names: []string{"0002_add_name.up.sql", "0002_other.up.sql"}, wantErr: `version 2 is used by "add_name" and "other"`},
+		{name: "missing up", names: []string{"0001_init.up.sql", "0004_no_up.down.sql"}, wantErr: "version 4 has no up migration"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := parseMigrations(test.names, read)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("parseMigrations() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(migrations, test.want) {
+				t.Errorf("parseMigrations() = %+v, want %+v", migrations, test.want)
+			}
+		})
+	}
+}